@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aydabd/mamba-githook/installer/internal/installer"
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	configPath string
+	// flagConfig holds the configuration values set on the command line.
+	flagConfig installer.Config
+	// effectiveConfig is the configuration merged from every source.
+	effectiveConfig *installer.EffectiveConfig
+)
+
+// applyConfig merges the config file, the environment and the flags and
+// configures the installer with the result.
+func applyConfig(inst *installer.Installer) error {
+	path := configPath
+	if path == "" {
+		path = inst.ConfigPath()
+	}
+	file, err := installer.LoadConfig(path, configPath != "")
+	if err != nil {
+		return err
+	}
+
+	effectiveConfig, err = inst.ResolveConfig(file, path, &flagConfig)
+	if err != nil {
+		return err
+	}
+	if err := inst.ApplyConfig(effectiveConfig); err != nil {
+		return err
+	}
+	layout.Prefix = effectiveConfig.Prefix
+	return nil
+}
+
+// runWizard asks for the installation settings, configures the installer
+// with the answers and saves them if requested.
+func runWizard(ctx context.Context, inst *installer.Installer, savePath string) error {
+	answers, err := inst.Wizard(ctx, os.Stdin, os.Stdout, effectiveConfig, savePath)
+	if err != nil {
+		return err
+	}
+
+	flagConfig = answers.Config
+	if err := applyConfig(inst); err != nil {
+		return err
+	}
+	if err := inst.ApplyLayout(ctx, layout); err != nil {
+		return err
+	}
+	if answers.SavePath != "" {
+		return inst.SaveConfig(ctx, answers.SavePath, &answers.Config)
+	}
+	return nil
+}
+
+// addConfigFlags adds the flags of the settings that can also be set in the
+// config file.
+func addConfigFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&flagConfig.Hooks, "hook", nil, "mamba-githook hook to enable, repeatable; defaults to all")
+	cmd.Flags().StringVar(&flagConfig.MicromambaDir, "micromamba-dir", "", "Directory of the micromamba executable")
+	cmd.Flags().StringVar(&flagConfig.TemplateDir, "template-dir", "", "Directory of project templates replacing the embedded ones")
+	cmd.Flags().StringVar(&flagConfig.HooksScope, "hooks-scope", "", "Set core.hooksPath globally (global) or leave it to each repository (repo)")
+}
+
+func createConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the installer configuration",
+		Long: `Inspect the installer configuration.
+
+Settings are read from the config file, by default installer.yaml in the
+mamba-githook config directory, or the file given with --config. Values are
+taken in this order of precedence: flags, MAMBA_GITHOOK_* environment
+variables, the config file and defaults.`,
+	}
+	cmd.AddCommand(createConfigShowCmd())
+	return cmd
+}
+
+func createConfigShowCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the effective configuration and the source of each value",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := effectiveConfig.PrintConfig(output, os.Stdout); err != nil {
+				log.Fatal().Err(err).Msg("Showing config failed")
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, json or yaml")
+	addShellFlag(cmd)
+	addConfigFlags(cmd)
+	return cmd
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
 	"github.com/aydabd/mamba-githook/installer/internal/installer"
 	"github.com/aydabd/mamba-githook/installer/internal/log"
 	"github.com/spf13/cobra"
@@ -13,21 +20,65 @@ var (
 		Long:  `A cross-platform installer for mamba-githook that handles installation, uninstallation, and updates.`,
 	}
 
-	verbose bool
+	verbose int
+	quiet   bool
+	noColor bool
+	dryRun  bool
+	layout  installer.LayoutOptions
+	system  bool
+	root    string
 )
 
 func init() {
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
-	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		if verbose {
-			log.SetVerbose()
-		}
-	}
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "Increase the log level, repeatable: -v for debug, -vv for trace with the source of every message")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only log errors")
+	rootCmd.PersistentFlags().StringVar(&flagConfig.LogLevel, "log-level", "", "Log level: "+strings.Join(log.Levels, ", "))
+	rootCmd.MarkFlagsMutuallyExclusive("verbose", "quiet", "log-level")
+	rootCmd.PersistentFlags().StringVar(&flagConfig.LogFormat, "log-format", "", "Log format: console, json or logfmt")
+	rootCmd.PersistentFlags().StringVar(&flagConfig.LogFile, "log-file", "", "Also write the log as JSON to this file")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colors in the log, also set by the NO_COLOR environment variable")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Print the planned changes without applying them")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Installer config file; defaults to installer.yaml in the mamba-githook config directory")
+	rootCmd.PersistentFlags().StringVar(&flagConfig.Prefix, "prefix", "", "Install into <prefix>/bin, <prefix>/share/mamba-githook and <prefix>/share/man")
+	rootCmd.PersistentFlags().StringVar(&layout.BinDir, "bin-dir", "", "Directory of the mamba-githook executable")
+	rootCmd.PersistentFlags().StringVar(&layout.DataDir, "data-dir", "", "Directory of the mamba-githook hooks and scripts")
+	rootCmd.PersistentFlags().StringVar(&layout.BackupDir, "backup-dir", "", "Directory of the backups")
+	rootCmd.PersistentFlags().BoolVar(&system, "system", false, "Install for all users under /usr/local with the system Git config and /etc/profile.d")
+	rootCmd.PersistentFlags().StringVar(&root, "root", "", "Stage a system installation below this directory, as package builders do")
 }
 
 func main() {
 	inst := installer.NewInstaller(srcFS)
 
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		applyVerbosity()
+		inst.SetDryRun(dryRun)
+		if root != "" && !system {
+			log.Fatal().Msg("--root requires --system")
+		}
+		if !system && cmd.Name() != "install" && inst.HasSystemLayoutOnly() {
+			// Act on the system installation rather than a missing user one
+			log.Debug().Msg("No user installation found, using the system installation")
+			system = true
+		}
+		if system {
+			if err := inst.UseSystemLayout(root); err != nil {
+				log.Fatal().Err(err).Msg("Invalid system installation")
+			}
+		}
+		if err := applyConfig(inst); err != nil {
+			log.Fatal().Err(err).Msg("Invalid configuration")
+		}
+		if err := inst.ApplyLayout(cmd.Context(), layout); err != nil {
+			log.Fatal().Err(err).Msg("Invalid directory layout")
+		}
+	}
+	rootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {
+		printPlan(inst)
+	}
+	// A failing dry run still shows the changes planned up to the failure
+	log.OnFatal(func() { printPlan(inst) })
+
 	rootCmd.AddCommand(
 		createInstallCmd(inst),
 		createUninstallCmd(inst),
@@ -35,90 +86,334 @@ func main() {
 		createBackupCmd(inst),
 		createRestoreCmd(inst),
 		createStatusCmd(inst),
+		createDoctorCmd(inst),
+		createVersionCmd(inst),
+		createConfigCmd(),
+		createAuditCmd(inst),
+		createRunCmd(),
 	)
 
-	if err := rootCmd.Execute(); err != nil {
+	// Ctrl-C cancels the running operation, which rolls back its changes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		log.Fatal().Err(err).Msg("Failed to execute command")
 	}
 }
 
+// applyVerbosity turns --quiet, --verbose and --no-color into log settings.
+func applyVerbosity() {
+	switch {
+	case quiet:
+		flagConfig.LogLevel = "error"
+	case verbose > 0:
+		flagConfig.LogLevel = log.VerbosityLevel(verbose)
+	}
+	if noColor {
+		log.SetNoColor()
+	}
+}
+
+// printPlan prints the changes planned by a dry run.
+func printPlan(inst *installer.Installer) {
+	if plan := inst.Plan(); plan != nil {
+		plan.Print(os.Stdout)
+	}
+}
+
+// exit exits with code after printing the dry run plan, as os.Exit skips
+// PersistentPostRun.
+func exit(inst *installer.Installer, code int) {
+	printPlan(inst)
+	os.Exit(code)
+}
+
 func createInstallCmd(inst *installer.Installer) *cobra.Command {
-	var nonInteractive bool
+	var (
+		nonInteractive bool
+		saveConfig     string
+	)
 	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "Install mamba-githook",
+		Long: `Install mamba-githook.
+
+On a terminal the installer asks which shells to configure, whether to set
+core.hooksPath globally or per repository, whether to install micromamba and
+which prefix to use. The answers can be saved to a config file and replayed
+with --non-interactive, e.g. in CI:
+
+  mamba-githook-installer --config answers.yaml install --non-interactive`,
 		Run: func(cmd *cobra.Command, args []string) {
+			if err := parseChainFlag(cmd, inst); err != nil {
+				log.Fatal().Err(err).Msg("Invalid --chain value")
+			}
+
+			if !nonInteractive && !installer.IsTerminal(os.Stdin) {
+				log.Info().Msg("Standard input is not a terminal, installing non-interactively")
+				nonInteractive = true
+			}
+
 			var err error
 			if nonInteractive {
-				err = inst.NonInteractiveInstall()
+				if saveConfig != "" {
+					err = inst.SaveConfig(cmd.Context(), saveConfig, effectiveConfig.Explicit())
+				}
+				if err == nil {
+					err = inst.NonInteractiveInstall(cmd.Context())
+				}
 			} else {
-				err = inst.Install()
+				err = runWizard(cmd.Context(), inst, saveConfig)
+				if err == nil {
+					err = inst.Install(cmd.Context())
+				}
 			}
 			if err != nil {
 				log.Fatal().Err(err).Msg("Installation failed")
 			}
 		},
 	}
-	cmd.Flags().BoolVarP(&nonInteractive, "non-interactive", "n", false, "Run in non-interactive mode")
+	cmd.Flags().BoolVarP(&nonInteractive, "non-interactive", "n", false, "Install with the configured settings without asking")
+	cmd.Flags().StringVar(&saveConfig, "save-config", "", "Save the chosen settings to this config file")
+	addShellFlag(cmd)
+	addChainFlag(cmd)
+	addConfigFlags(cmd)
 	return cmd
 }
 
 func createUninstallCmd(inst *installer.Installer) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "uninstall",
 		Short: "Uninstall mamba-githook",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := inst.Uninstall(); err != nil {
+			if err := inst.Uninstall(cmd.Context()); err != nil {
 				log.Fatal().Err(err).Msg("Uninstallation failed")
 			}
 		},
 	}
+	addShellFlag(cmd)
+	return cmd
 }
 
 func createUpgradeCmd(inst *installer.Installer) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "upgrade",
 		Short: "Upgrade mamba-githook",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := inst.Upgrade(); err != nil {
+			if err := parseChainFlag(cmd, inst); err != nil {
+				log.Fatal().Err(err).Msg("Invalid --chain value")
+			}
+			if err := inst.Upgrade(cmd.Context(), os.Stdout); err != nil {
 				log.Fatal().Err(err).Msg("Upgrade failed")
 			}
 		},
 	}
+	cmd.Flags().BoolVar(&inst.Force, "force", false, "Reinstall even if the installed version is current")
+	cmd.Flags().BoolVar(&inst.AllowDowngrade, "allow-downgrade", false, "Allow replacing a newer installation with this version")
+	addShellFlag(cmd)
+	addChainFlag(cmd)
+	addConfigFlags(cmd)
+	return cmd
 }
 
 func createBackupCmd(inst *installer.Installer) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "backup",
 		Short: "Backup mamba-githook installation",
+		Long: `Backup mamba-githook installation.
+
+Every backup is stored as its own generation named after the time it was
+created and the backed up version.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := inst.Backup(); err != nil {
+			if err := inst.Backup(cmd.Context()); err != nil {
 				log.Fatal().Err(err).Msg("Backup failed")
 			}
 		},
 	}
+	cmd.Flags().BoolVarP(&inst.CompressBackups, "compress", "z", false, "Store the backup as a tar.gz archive")
+	cmd.Flags().IntVar(&inst.KeepBackups, "keep", inst.KeepBackups, "Number of backups to keep, 0 keeps all")
+	cmd.AddCommand(createBackupListCmd(inst), createBackupVerifyCmd(inst))
+	return cmd
+}
+
+func createBackupListCmd(inst *installer.Installer) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the backups, oldest first",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := inst.ListBackups(cmd.Context(), output, os.Stdout); err != nil {
+				log.Fatal().Err(err).Msg("Listing backups failed")
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, json or yaml")
+	return cmd
+}
+
+func createBackupVerifyCmd(inst *installer.Installer) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "verify [id...]",
+		Short: "Verify backups against their checksums",
+		Long: `Verify backups against their checksums, all backups unless IDs are given.
+
+The checksum of the backup information of every backup is kept in the state
+directory, outside of the backups, so a backup whose files and checksums were
+both edited is still reported as corrupt.
+
+The exit code is 2 when a backup is corrupt.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := inst.VerifyBackups(cmd.Context(), args, output, os.Stdout); err != nil {
+				if errors.Is(err, installer.ErrCorruptBackup) {
+					exit(inst, 2)
+				}
+				log.Fatal().Err(err).Msg("Backup verification failed")
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, json or yaml")
+	return cmd
 }
 
 func createRestoreCmd(inst *installer.Installer) *cobra.Command {
-	return &cobra.Command{
+	var from string
+	cmd := &cobra.Command{
 		Use:   "restore",
 		Short: "Restore mamba-githook installation from backup",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := inst.Restore(); err != nil {
+			if err := inst.Restore(cmd.Context(), from); err != nil {
 				log.Fatal().Err(err).Msg("Restore failed")
 			}
 		},
 	}
+	cmd.Flags().StringVar(&from, "from", "", "ID of the backup to restore, see backup list; defaults to the most recent")
+	cmd.Flags().BoolVar(&inst.Force, "force", false, "Restore a backup that fails verification")
+	return cmd
 }
 
 func createStatusCmd(inst *installer.Installer) *cobra.Command {
-	return &cobra.Command{
+	var output string
+	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Check the status of mamba-githook installation",
+		Long: `Check the status of mamba-githook installation.
+
+The exit code is 2 when the installation is missing or degraded.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := inst.Status(); err != nil {
+			if err := inst.Status(cmd.Context(), output, os.Stdout); err != nil {
+				if errors.Is(err, installer.ErrDegraded) {
+					exit(inst, 2)
+				}
 				log.Fatal().Err(err).Msg("Status check failed")
 			}
 		},
 	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, json or yaml")
+	addShellFlag(cmd)
+	return cmd
+}
+
+func createDoctorCmd(inst *installer.Installer) *cobra.Command {
+	var fix bool
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose common problems with the mamba-githook installation",
+		Long: `Diagnose common problems with the mamba-githook installation.
+
+With --fix, problems that can be repaired automatically are fixed. The exit
+code is 2 when problems remain.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if _, err := inst.Doctor(cmd.Context(), fix, os.Stdout); err != nil {
+				if errors.Is(err, installer.ErrDegraded) {
+					exit(inst, 2)
+				}
+				log.Fatal().Err(err).Msg("Doctor failed")
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&fix, "fix", false, "Repair the problems that can be fixed automatically")
+	addShellFlag(cmd)
+	return cmd
+}
+
+func createVersionCmd(inst *installer.Installer) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show the installer, embedded and installed mamba-githook versions",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := inst.PrintVersion(cmd.Context(), output, os.Stdout); err != nil {
+				log.Fatal().Err(err).Msg("Version check failed")
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, json or yaml")
+	return cmd
+}
+
+func createAuditCmd(inst *installer.Installer) *cobra.Command {
+	var (
+		output       string
+		since, until string
+		filter       installer.AuditFilter
+	)
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show the audit log of installer operations",
+		Long: `Show the audit log of installer operations.
+
+Every operation that changes the installation is appended to audit.jsonl in
+the mamba-githook state directory with the command, user, host, version,
+files touched, git config changes, outcome and duration. Dates are given as
+YYYY-MM-DD or RFC 3339 times, --until includes the whole day.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			if since != "" {
+				if filter.Since, err = installer.ParseAuditTime(since, false); err != nil {
+					log.Fatal().Err(err).Msg("Invalid --since value")
+				}
+			}
+			if until != "" {
+				if filter.Until, err = installer.ParseAuditTime(until, true); err != nil {
+					log.Fatal().Err(err).Msg("Invalid --until value")
+				}
+			}
+			if err := inst.PrintAudit(cmd.Context(), filter, output, os.Stdout); err != nil {
+				log.Fatal().Err(err).Msg("Reading the audit log failed")
+			}
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "Only show operations from this date on")
+	cmd.Flags().StringVar(&until, "until", "", "Only show operations up to this date")
+	cmd.Flags().StringSliceVar(&filter.Operations, "operation", nil,
+		"Only show this operation, repeatable (install, uninstall, upgrade, backup, restore, doctor, save-config)")
+	cmd.Flags().BoolVar(&filter.GitConfig, "git-config", false, "Only show operations that changed the git config")
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, json or yaml")
+	return cmd
+}
+
+// addShellFlag lets a command choose the shells whose startup files are managed.
+func addShellFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&flagConfig.Shells, "shell", nil,
+		"Shell to configure, repeatable (bash, zsh, sh, fish, tcsh, csh, nu, pwsh, xonsh); defaults to $SHELL")
+}
+
+// addChainFlag lets a command choose which displaced hooks are chained.
+func addChainFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("chain", nil,
+		"Chain displaced hooks after mamba-githook, repeatable: <hook>[=global|local|all|none], all[=mode] or none; "+
+			"defaults to chaining the repository's .git/hooks and a displaced global core.hooksPath")
+}
+
+func parseChainFlag(cmd *cobra.Command, inst *installer.Installer) error {
+	if !cmd.Flags().Changed("chain") {
+		return nil
+	}
+	specs, err := cmd.Flags().GetStringSlice("chain")
+	if err != nil {
+		return err
+	}
+	inst.Chain, err = installer.ParseChainSpecs(specs)
+	return err
 }
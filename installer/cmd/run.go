@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aydabd/mamba-githook/installer/internal/installer"
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+	"github.com/spf13/cobra"
+)
+
+func createRunCmd() *cobra.Command {
+	var (
+		dir  string
+		jobs int
+	)
+	cmd := &cobra.Command{
+		Use:   "run <hook-type> [args...]",
+		Short: "Run the project hooks of a git hook type",
+		Long: `Run the project hooks of a git hook type.
+
+Hooks are the executable files named <hook-type>.<priority>.<name> in the
+project hooks directory, by default $MAMBA_GITHOOK_PROJECT_GITHOOKS_DIR or
+.githooks.d in the repository root. They run in the order of their numeric
+priority, lowest first, then by name; hooks without a priority run last, one
+at a time. Hooks sharing a priority run concurrently, up to --jobs at a
+time. The output of every hook is buffered and printed under a header with
+its result once it finishes.
+
+Every hook gets the arguments of the git hook and, for the hook types git
+passes input to such as pre-push, its standard input. All hooks run even if
+one fails, the exit code is 1 when any of them failed.
+
+The hooks installed by mamba-githook still run the project hooks with
+"mamba-githook run-hooks" in the micromamba environment of the project. This
+command runs them without it, e.g. to try them or in CI:
+
+  mamba-githook-installer run pre-push origin https://example.com/repo.git`,
+		Args: cobra.MinimumNArgs(1),
+		// Only the logging flags and environment variables apply: a broken
+		// installer.yaml or layout must not block commits
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			applyVerbosity()
+			if err := applyLogFlags(); err != nil {
+				log.Fatal().Err(err).Msg("Invalid log settings")
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if jobs < 1 {
+				log.Fatal().Msg("--jobs must be at least 1")
+			}
+			if dir == "" {
+				var err error
+				if dir, err = installer.ProjectHooksDir(cmd.Context()); err != nil {
+					log.Fatal().Err(err).Msg("Failed to find the project hooks")
+				}
+			}
+
+			run := &installer.HookRun{
+				Type:   args[0],
+				Dir:    dir,
+				Args:   args[1:],
+				Stdout: os.Stdout,
+				DryRun: dryRun,
+				Jobs:   jobs,
+			}
+			// A terminal means git passed no input, do not wait for it
+			if !installer.IsTerminal(os.Stdin) {
+				run.Stdin = os.Stdin
+			}
+			if _, err := run.Run(cmd.Context()); err != nil {
+				log.Fatal().Err(err).Msg("Hooks failed")
+			}
+		},
+	}
+	// Arguments after the hook type belong to the hooks
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory of the project hooks")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Number of hooks of the same priority to run at the same time")
+	return cmd
+}
+
+// applyLogFlags applies the log format, level and file given as flags or,
+// without a flag, in the environment.
+func applyLogFlags() error {
+	setting := func(flag, key string) string {
+		if flag != "" {
+			return flag
+		}
+		return installer.EnvValue(key)
+	}
+
+	if format := setting(flagConfig.LogFormat, "log_format"); format != "" {
+		if err := log.SetFormat(format); err != nil {
+			return err
+		}
+	}
+	if level := setting(flagConfig.LogLevel, "log_level"); level != "" {
+		if err := log.SetLevel(level); err != nil {
+			return err
+		}
+	}
+	if file := setting(flagConfig.LogFile, "log_file"); file != "" {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("invalid log file %q: %w", file, err)
+		}
+		return log.SetFile(path)
+	}
+	return nil
+}
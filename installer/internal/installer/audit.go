@@ -0,0 +1,199 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+const auditFileName = "audit.jsonl"
+
+// auditTrail collects the changes made by the running operation.
+type auditTrail struct {
+	files     []string
+	gitConfig []log.GitConfigChange
+}
+
+// AuditPath returns the location of the audit log.
+func (i *Installer) AuditPath() string {
+	return filepath.Join(i.StateDir, auditFileName)
+}
+
+// startAudit tags the log messages of ctx with operation op and begins
+// recording its changes. The returned function appends the audit record
+// with the outcome in *err and is meant to be deferred. Operations run by
+// another operation are part of its record, dry runs are not recorded and
+// neither are installations staged below Root, which are not made on this
+// host.
+func (i *Installer) startAudit(ctx context.Context, op string) (context.Context, func(err *error)) {
+	ctx = log.WithOperation(ctx, op)
+	if i.audit != nil || i.DryRun || i.Root != "" {
+		return ctx, func(*error) {}
+	}
+	i.audit = &auditTrail{}
+	start := time.Now()
+
+	return ctx, func(err *error) {
+		trail := i.audit
+		i.audit = nil
+
+		record := log.AuditRecord{
+			Time:        start.UTC(),
+			Operation:   op,
+			OperationID: log.OperationID(ctx),
+			Command:     strings.Join(os.Args, " "),
+			User:        currentUser(),
+			Version:     Version,
+			Files:       trail.files,
+			GitConfig:   trail.gitConfig,
+			Outcome:     log.OutcomeSuccess,
+			DurationMS:  time.Since(start).Milliseconds(),
+		}
+		record.Host, _ = os.Hostname()
+		if *err != nil {
+			record.Outcome = log.OutcomeFailure
+			record.Error = (*err).Error()
+		}
+		if err := log.WriteAudit(i.AuditPath(), record); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("Failed to write the audit log")
+		}
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// auditFile records that the running operation wrote or removed path.
+func (i *Installer) auditFile(path string) {
+	if i.audit != nil && !slices.Contains(i.audit.files, path) {
+		i.audit.files = append(i.audit.files, path)
+	}
+}
+
+// auditGitConfig records a git config change of the running operation.
+func (i *Installer) auditGitConfig(change log.GitConfigChange) {
+	if i.audit != nil {
+		i.audit.gitConfig = append(i.audit.gitConfig, change)
+	}
+}
+
+// auditUndo records a change undone by a transaction rollback.
+func (i *Installer) auditUndo(step journalStep) {
+	switch step.Kind {
+	case stepCreateFile, stepReplaceFile:
+		i.auditFile(step.Path)
+	case stepGitConfig:
+		i.auditGitConfig(log.GitConfigChange{Scope: step.Scope, Key: step.Key, Value: step.Previous, Unset: !step.HadPrevious})
+	}
+}
+
+// AuditFilter selects audit records. Zero values match every record.
+type AuditFilter struct {
+	Since time.Time
+	Until time.Time
+	// Operations lists the operations to include.
+	Operations []string
+	// GitConfig only includes operations that changed the git config.
+	GitConfig bool
+}
+
+func (f AuditFilter) match(r log.AuditRecord) bool {
+	switch {
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && r.Time.After(f.Until):
+		return false
+	case len(f.Operations) > 0 && !slices.Contains(f.Operations, r.Operation):
+		return false
+	case f.GitConfig && len(r.GitConfig) == 0:
+		return false
+	}
+	return true
+}
+
+// ParseAuditTime parses an RFC 3339 time or a date. A date is the start of
+// the day, or its end when end is set, in local time.
+func ParseAuditTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected YYYY-MM-DD or RFC 3339", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// AuditRecords returns the records of the audit log matching filter.
+func (i *Installer) AuditRecords(ctx context.Context, filter AuditFilter) ([]log.AuditRecord, error) {
+	records, err := log.ReadAudit(i.AuditPath())
+	if err != nil {
+		return nil, err
+	}
+	matching := []log.AuditRecord{}
+	for _, record := range records {
+		if filter.match(record) {
+			matching = append(matching, record)
+		}
+	}
+	return matching, nil
+}
+
+// PrintAudit prints the audit records matching filter in the given output
+// format: text, json or yaml.
+func (i *Installer) PrintAudit(ctx context.Context, filter AuditFilter, output string, w io.Writer) error {
+	records, err := i.AuditRecords(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if output != "text" {
+		if err := encodeOutput(w, output, records); err != nil {
+			return fmt.Errorf("failed to encode audit records: %w", err)
+		}
+		return nil
+	}
+
+	if len(records) == 0 {
+		fmt.Fprintf(w, "No audit records in %s\n", i.AuditPath())
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tOPERATION\tUSER\tHOST\tOUTCOME\tDURATION\tFILES\tGIT CONFIG")
+	for _, r := range records {
+		var changes []string
+		for _, c := range r.GitConfig {
+			if c.Unset {
+				changes = append(changes, fmt.Sprintf("%s %s unset", c.Scope, c.Key))
+			} else {
+				changes = append(changes, fmt.Sprintf("%s %s=%s", c.Scope, c.Key, c.Value))
+			}
+		}
+		if len(changes) == 0 {
+			changes = []string{"-"}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			r.Time.Local().Format(time.RFC3339), r.Operation, r.User, r.Host, r.Outcome,
+			time.Duration(r.DurationMS)*time.Millisecond, len(r.Files), strings.Join(changes, ", "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,818 @@
+package installer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+const (
+	backupInfoFile = "backup.json"
+	// legacyBackupDir is the directory in the home directory that held the
+	// backups before they moved to the state directory.
+	legacyBackupDir = ".mamba-githook-backup"
+	backupArchive   = ".tar.gz"
+	backupTimestamp = "20060102T150405Z"
+	// legacyBackupID names the single backup written into BackupDir itself
+	// by installers before backup generations.
+	legacyBackupID = "legacy"
+)
+
+// BackupInfo describes one backup generation.
+type BackupInfo struct {
+	ID               string    `json:"id" yaml:"id"`
+	CreatedAt        time.Time `json:"created_at" yaml:"created_at"`
+	Version          string    `json:"version,omitempty" yaml:"version,omitempty"`
+	InstallerVersion string    `json:"installer_version" yaml:"installer_version"`
+	Compressed       bool      `json:"compressed" yaml:"compressed"`
+	// Complete is set for backups that capture the system settings below,
+	// older backups only hold the installed files.
+	Complete bool `json:"complete" yaml:"complete"`
+	// Shells holds the managed block of every shell rc file, or of the
+	// profile snippet of a system installation.
+	Shells []ShellBackup `json:"shells,omitempty" yaml:"shells,omitempty"`
+	// HooksPath is the core.hooksPath of the scope the installation uses.
+	HooksPath *SettingBackup `json:"hooks_path,omitempty" yaml:"hooks_path,omitempty"`
+	// Env holds the Windows user environment variables set by the installer.
+	Env map[string]SettingBackup `json:"env,omitempty" yaml:"env,omitempty"`
+	// Checksums is the SHA-256 of the SHA256SUMS file of the backup.
+	Checksums string `json:"checksums_sha256,omitempty" yaml:"checksums_sha256,omitempty"`
+	// Path is the generation directory or archive, it is not stored.
+	Path string `json:"-" yaml:"path"`
+}
+
+// ShellBackup is the managed block of a shell rc file. Lines is empty when
+// the file has no block.
+type ShellBackup struct {
+	Shell      string   `json:"shell" yaml:"shell"`
+	ConfigFile string   `json:"config_file" yaml:"config_file"`
+	Lines      []string `json:"lines,omitempty" yaml:"lines,omitempty"`
+}
+
+// SettingBackup is the value of a setting that may be unset.
+type SettingBackup struct {
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	Set   bool   `json:"set" yaml:"set"`
+}
+
+// Backup saves the installation as a new backup generation in BackupDir and
+// removes the oldest generations beyond KeepBackups.
+func (i *Installer) Backup(ctx context.Context) (err error) {
+	ctx, end := i.startAudit(ctx, "backup")
+	defer end(&err)
+	log.Ctx(ctx).Info().Msg("Creating backup")
+
+	if err := i.mkdirAll(ctx, i.BackupDir); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	version, err := i.InstalledVersion(ctx)
+	if err != nil {
+		return err
+	}
+	info := BackupInfo{
+		CreatedAt:        time.Now().UTC(),
+		Version:          version,
+		InstallerVersion: Version,
+		Compressed:       i.CompressBackups,
+		Complete:         true,
+	}
+	info.ID = i.newBackupID(info.CreatedAt, version)
+
+	dir := filepath.Join(i.BackupDir, info.ID)
+	if err := i.mkdirAll(ctx, dir); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	complete := false
+	defer func() {
+		// Do not leave an incomplete generation behind, e.g. when cancelled
+		if err != nil && !complete && !i.DryRun {
+			os.RemoveAll(dir)
+			os.Remove(dir + backupArchive + ".tmp")
+		}
+	}()
+
+	if err := i.copyDir(ctx, i.TargetDir, filepath.Join(dir, "target")); err != nil {
+		return fmt.Errorf("failed to backup target directory: %w", err)
+	}
+
+	if err := i.copyFile(ctx, filepath.Join(i.BinDir, "mamba-githook"), filepath.Join(dir, "mamba-githook")); err != nil {
+		return fmt.Errorf("failed to backup mamba-githook binary: %w", err)
+	}
+
+	if err := i.backupSettings(ctx, dir, &info); err != nil {
+		return err
+	}
+
+	if info.Checksums, err = i.writeBackupChecksums(ctx, dir); err != nil {
+		return fmt.Errorf("failed to write backup checksums: %w", err)
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	infoFile, err := i.writeFile(ctx, filepath.Join(dir, backupInfoFile), strings.NewReader(string(data)+"\n"), 0644, "generated")
+	if err != nil {
+		return fmt.Errorf("failed to write backup information: %w", err)
+	}
+	if err := i.recordBackupInfoChecksum(ctx, info.ID, infoFile.SHA256); err != nil {
+		return err
+	}
+
+	if info.Compressed {
+		if err := i.compressBackup(ctx, dir); err != nil {
+			return fmt.Errorf("failed to compress backup: %w", err)
+		}
+	}
+
+	complete = true
+
+	if err := i.pruneBackups(ctx); err != nil {
+		return fmt.Errorf("backup %s was created but removing old backups failed: %w", info.ID, err)
+	}
+
+	log.Ctx(ctx).Info().Msgf("Backup %s created successfully", info.ID)
+	return nil
+}
+
+// backupSettings saves the man page into the backup generation directory
+// and records the shell rc blocks, git configuration and Windows user
+// environment in info.
+func (i *Installer) backupSettings(ctx context.Context, dir string, info *BackupInfo) error {
+	if i.OS == "windows" {
+		info.Env = make(map[string]SettingBackup)
+		for _, key := range []string{"PATH", "MAMBA_GITHOOK_DIR", micromambaDirEnv} {
+			value, ok, err := getUserEnv(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to backup environment variable %s: %w", key, err)
+			}
+			info.Env[key] = SettingBackup{Value: value, Set: ok}
+		}
+	} else {
+		manPage := filepath.Join(i.ManDir, "mamba-githook.1")
+		if _, err := os.Stat(manPage); err == nil {
+			manDir := filepath.Join(dir, "man", "man1")
+			if err := i.mkdirAll(ctx, manDir); err != nil {
+				return err
+			}
+			if err := i.copyFile(ctx, manPage, filepath.Join(manDir, "mamba-githook.1")); err != nil {
+				return fmt.Errorf("failed to backup man page: %w", err)
+			}
+		}
+
+		configFiles := make(map[string]string)
+		if i.System {
+			configFiles["profile"] = i.profileSnippetPath()
+		} else {
+			for _, name := range supportedShells() {
+				configFiles[name] = shellEnvs[name].ConfigFile(i.HomeDir)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(configFiles)) {
+			configFile := configFiles[name]
+			lines, _, err := readManagedBlock(configFile)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to backup %s: %w", configFile, err)
+			}
+			info.Shells = append(info.Shells, ShellBackup{Shell: name, ConfigFile: configFile, Lines: lines})
+		}
+	}
+
+	hooksPath, ok, err := gitConfigGet(ctx, i.gitScope(), "core.hooksPath")
+	if err != nil {
+		return fmt.Errorf("failed to backup core.hooksPath: %w", err)
+	}
+	info.HooksPath = &SettingBackup{Value: hooksPath, Set: ok}
+	return nil
+}
+
+// newBackupID names a backup generation after its creation time and the
+// backed up version.
+func (i *Installer) newBackupID(createdAt time.Time, version string) string {
+	if version == "" {
+		version = "unknown"
+	}
+	base := createdAt.Format(backupTimestamp) + "-" + version
+	id := base
+	for n := 2; ; n++ {
+		_, errDir := os.Stat(filepath.Join(i.BackupDir, id))
+		_, errArchive := os.Stat(filepath.Join(i.BackupDir, id+backupArchive))
+		if os.IsNotExist(errDir) && os.IsNotExist(errArchive) {
+			return id
+		}
+		id = fmt.Sprintf("%s.%d", base, n)
+	}
+}
+
+// compressBackup replaces a backup generation directory by a tar.gz archive.
+func (i *Installer) compressBackup(ctx context.Context, dir string) error {
+	archive := dir + backupArchive
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "archive", Target: archive, Detail: "from " + dir})
+		return i.removeAll(ctx, dir)
+	}
+
+	tmp := archive + ".tmp"
+	if err := writeArchive(dir, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, archive); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// writeArchive writes the content of dir into a gzip compressed tar file.
+// The backup information is written first so that it can be listed without
+// reading the whole archive.
+func writeArchive(dir, archive string) error {
+	f, err := os.OpenFile(archive, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var paths []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	infoPath := filepath.Join(dir, backupInfoFile)
+	slices.SortStableFunc(paths, func(a, b string) int {
+		switch {
+		case a == infoPath:
+			return -1
+		case b == infoPath:
+			return 1
+		}
+		return 0
+	})
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := copyInto(tw, path); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func copyInto(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// extractArchive unpacks a backup archive into dir.
+func extractArchive(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path %q in backup archive", header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readArchiveInfo reads the backup information stored at the start of a
+// backup archive.
+func readArchiveInfo(archive string) (*BackupInfo, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is missing in %s", backupInfoFile, archive)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == backupInfoFile {
+			var info BackupInfo
+			if err := json.NewDecoder(tr).Decode(&info); err != nil {
+				return nil, err
+			}
+			return &info, nil
+		}
+	}
+}
+
+// Backups returns the backup generations in BackupDir, oldest first.
+func (i *Installer) Backups(ctx context.Context) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(i.BackupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		path := filepath.Join(i.BackupDir, entry.Name())
+		var info *BackupInfo
+		switch {
+		case entry.IsDir():
+			data, err := os.ReadFile(filepath.Join(path, backupInfoFile))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			info = &BackupInfo{}
+			err = json.Unmarshal(data, info)
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msgf("Skipping unreadable backup %s", path)
+				continue
+			}
+			info.Compressed = false
+		case strings.HasSuffix(entry.Name(), backupArchive):
+			info, err = readArchiveInfo(path)
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msgf("Skipping unreadable backup %s", path)
+				continue
+			}
+			info.Compressed = true
+		default:
+			continue
+		}
+		info.Path = path
+		backups = append(backups, *info)
+	}
+
+	if fi, err := os.Stat(filepath.Join(i.BackupDir, "target")); err == nil && fi.IsDir() {
+		backups = append(backups, BackupInfo{
+			ID:        legacyBackupID,
+			CreatedAt: fi.ModTime().UTC(),
+			Path:      i.BackupDir,
+		})
+	}
+
+	slices.SortFunc(backups, func(a, b BackupInfo) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return backups, nil
+}
+
+// findBackup returns the backup generation with the given ID, or the most
+// recent one when id is empty.
+func (i *Installer) findBackup(ctx context.Context, id string) (*BackupInfo, error) {
+	backups, err := i.Backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("no backup found in %s", i.BackupDir)
+	}
+	if id == "" {
+		return &backups[len(backups)-1], nil
+	}
+	for n := range backups {
+		if backups[n].ID == id {
+			return &backups[n], nil
+		}
+	}
+	return nil, fmt.Errorf("backup %q not found, see backup list", id)
+}
+
+// migrateBackupDir moves the backups of a user installation from the legacy
+// directory in the home directory to dir, once. Installations whose layout
+// records the legacy directory switch to dir as well.
+func (i *Installer) migrateBackupDir(ctx context.Context, saved *Layout, dir string) error {
+	legacy := filepath.Join(i.HomeDir, legacyBackupDir)
+	if i.System || i.HomeDir == "" || (saved != nil && saved.BackupDir != legacy) {
+		return nil
+	}
+
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		i.BackupDir = dir
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check legacy backup directory: %w", err)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		log.Ctx(ctx).Warn().Msgf("Backups exist in both %s and %s, leaving %s in place", legacy, dir, legacy)
+		i.BackupDir = dir
+		return nil
+	}
+
+	if i.DryRun {
+		// Keep reading the backups where they are
+		i.plan.add(PlanAction{Op: "move", Target: dir, Detail: "from " + legacy})
+		i.BackupDir = legacy
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := os.Rename(legacy, dir); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("Failed to move the backups from %s to %s, keeping them in place", legacy, dir)
+		i.BackupDir = legacy
+		return nil
+	}
+	log.Ctx(ctx).Info().Msgf("Moved the backups from %s to %s", legacy, dir)
+	i.BackupDir = dir
+
+	if saved != nil {
+		if err := i.writeLayout(ctx); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("Failed to record the new backup directory in the layout")
+		}
+	}
+	return nil
+}
+
+// pruneBackups removes the oldest backup generations so that at most
+// KeepBackups remain. Zero keeps every generation.
+func (i *Installer) pruneBackups(ctx context.Context) error {
+	if err := i.removeIncompleteBackups(ctx); err != nil {
+		return err
+	}
+	if i.KeepBackups <= 0 {
+		return nil
+	}
+	backups, err := i.Backups(ctx)
+	if err != nil {
+		return err
+	}
+	for len(backups) > i.KeepBackups {
+		oldest := backups[0]
+		backups = backups[1:]
+		log.Ctx(ctx).Info().Msgf("Removing old backup %s", oldest.ID)
+
+		var err error
+		switch {
+		case oldest.ID == legacyBackupID:
+			if err = i.removeAll(ctx, filepath.Join(i.BackupDir, "target")); err == nil {
+				err = i.removeFile(ctx, filepath.Join(i.BackupDir, "mamba-githook"))
+			}
+		case oldest.Compressed:
+			err = i.removeFile(ctx, oldest.Path)
+		default:
+			err = i.removeAll(ctx, oldest.Path)
+		}
+		if err == nil {
+			err = i.recordBackupInfoChecksum(ctx, oldest.ID, "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIncompleteBackups removes the generation directories without backup
+// information and the partial archives left behind by backups that were
+// killed before they could clean up.
+func (i *Installer) removeIncompleteBackups(ctx context.Context) error {
+	entries, err := os.ReadDir(i.BackupDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		// Only generations are named after their creation time, which keeps
+		// the legacy backup in BackupDir itself out of this
+		if len(name) < len(backupTimestamp) {
+			continue
+		}
+		if _, err := time.Parse(backupTimestamp, name[:len(backupTimestamp)]); err != nil {
+			continue
+		}
+
+		path := filepath.Join(i.BackupDir, name)
+		switch {
+		case entry.IsDir():
+			if _, err := os.Stat(filepath.Join(path, backupInfoFile)); !os.IsNotExist(err) {
+				continue
+			}
+			log.Ctx(ctx).Warn().Msgf("Removing incomplete backup %s", name)
+			if err := i.removeAll(ctx, path); err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, backupArchive+".tmp"):
+			log.Ctx(ctx).Warn().Msgf("Removing incomplete backup archive %s", name)
+			if err := i.removeFile(ctx, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListBackups prints the backup generations in the given output format:
+// text, json or yaml.
+func (i *Installer) ListBackups(ctx context.Context, output string, w io.Writer) error {
+	ctx = log.WithOperation(ctx, "backup-list")
+	backups, err := i.Backups(ctx)
+	if err != nil {
+		return err
+	}
+	if output != "text" {
+		if backups == nil {
+			backups = []BackupInfo{}
+		}
+		if err := encodeOutput(w, output, backups); err != nil {
+			return fmt.Errorf("failed to encode backups: %w", err)
+		}
+		return nil
+	}
+
+	if len(backups) == 0 {
+		fmt.Fprintf(w, "No backups in %s\n", i.BackupDir)
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tVERSION\tFORMAT")
+	for _, backup := range backups {
+		version, format := backup.Version, "directory"
+		if version == "" {
+			version = "unknown"
+		}
+		if backup.Compressed {
+			format = "tar.gz"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", backup.ID, backup.CreatedAt.Local().Format(time.DateTime), version, format)
+	}
+	return tw.Flush()
+}
+
+// Restore puts back the backup generation with the given ID, or the most
+// recent one when id is empty. Everything is restored in one transaction
+// that is rolled back if any step fails.
+func (i *Installer) Restore(ctx context.Context, id string) (err error) {
+	ctx, end := i.startAudit(ctx, "restore")
+	defer end(&err)
+	log.Ctx(ctx).Info().Msg("Starting restore from backup")
+
+	backup, err := i.findBackup(ctx, id)
+	if err != nil {
+		return err
+	}
+	log.Ctx(ctx).Info().Msgf("Restoring backup %s", backup.ID)
+
+	dir, problems, cleanup, err := openBackup(backup)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := i.checkBackup(ctx, dir, backup, problems); err != nil {
+		return err
+	}
+
+	if err := i.beginTransaction(log.WithStep(ctx, "transaction")); err != nil {
+		return err
+	}
+	if err := i.restoreFrom(log.WithStep(ctx, "restore"), dir, backup); err != nil {
+		if rollbackErr := i.rollbackTransaction(log.WithStep(ctx, "rollback")); rollbackErr != nil {
+			log.Ctx(ctx).Error().Err(rollbackErr).Msg("Failed to roll back restore")
+		}
+		return err
+	}
+	if err := i.commitTransaction(); err != nil {
+		return err
+	}
+
+	log.Ctx(ctx).Info().Msg("Restore completed successfully")
+	return nil
+}
+
+// restoreFrom restores the installation and the system settings recorded in
+// a backup generation directory.
+func (i *Installer) restoreFrom(ctx context.Context, dir string, backup *BackupInfo) error {
+	if err := i.restoreTree(ctx, filepath.Join(dir, "target"), i.TargetDir); err != nil {
+		return fmt.Errorf("failed to restore target directory: %w", err)
+	}
+
+	if err := i.mkdirAll(ctx, i.BinDir); err != nil {
+		return err
+	}
+	if err := i.restoreFile(ctx, filepath.Join(dir, "mamba-githook"), filepath.Join(i.BinDir, "mamba-githook"), 0755); err != nil {
+		return fmt.Errorf("failed to restore mamba-githook binary: %w", err)
+	}
+
+	if i.OS != "windows" {
+		manPage := filepath.Join(i.ManDir, "mamba-githook.1")
+		manPageSrc := filepath.Join(dir, "man", "man1", "mamba-githook.1")
+		if _, err := os.Stat(manPageSrc); err == nil {
+			if err := i.mkdirAll(ctx, i.ManDir); err != nil {
+				return err
+			}
+			if err := i.restoreFile(ctx, manPageSrc, manPage, 0644); err != nil {
+				return fmt.Errorf("failed to restore man page: %w", err)
+			}
+		} else if backup.Complete {
+			if err := i.removeFile(ctx, manPage); err != nil {
+				return fmt.Errorf("failed to remove man page: %w", err)
+			}
+		}
+	}
+
+	for _, shell := range backup.Shells {
+		if err := i.writeManagedBlock(ctx, shell.ConfigFile, shell.Lines); err != nil {
+			return fmt.Errorf("failed to restore %s environment: %w", shell.Shell, err)
+		}
+	}
+
+	if backup.HooksPath != nil {
+		var err error
+		if backup.HooksPath.Set {
+			err = i.setGitConfig(ctx, i.gitScope(), "core.hooksPath", backup.HooksPath.Value)
+		} else {
+			err = i.unsetGitConfig(ctx, i.gitScope(), "core.hooksPath")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore core.hooksPath: %w", err)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(backup.Env)) {
+		value := backup.Env[key]
+		var err error
+		if value.Set {
+			err = i.setUserEnv(ctx, key, value.Value)
+		} else {
+			err = i.unsetUserEnv(ctx, key)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore environment variable %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// restoreTree makes dst a copy of the backed up directory src, removing the
+// files that are not part of the backup.
+func (i *Installer) restoreTree(ctx context.Context, src, dst string) error {
+	restored := make(map[string]bool)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		if d.IsDir() {
+			return i.mkdirAll(ctx, target)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		restored[target] = true
+		return i.restoreFile(ctx, path, target, restoredMode(relPath, info.Mode()))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || restored[path] {
+			return err
+		}
+		return i.removeFile(ctx, path)
+	})
+	if os.IsNotExist(err) {
+		// Only in a dry run, where dst is not created
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return i.pruneEmptyDirs(ctx, dst)
+}
+
+// restoredMode returns the mode of a restored TargetDir file. Backups made
+// before copyFile kept file modes lost the executable bit of the hooks.
+func restoredMode(relPath string, mode fs.FileMode) fs.FileMode {
+	relPath = filepath.ToSlash(relPath)
+	if strings.HasPrefix(relPath, "hooks/") || strings.HasPrefix(relPath, "chain/") {
+		return mode.Perm() | 0111
+	}
+	return mode.Perm()
+}
+
+func (i *Installer) restoreFile(ctx context.Context, src, dst string, mode fs.FileMode) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = i.writeFile(ctx, dst, f, mode, "backup")
+	return err
+}
@@ -0,0 +1,71 @@
+package installer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateBackupDir(t *testing.T) {
+	tests := []struct {
+		name string
+		// savedBackupDir is the backup directory of the persisted layout
+		// relative to the home directory, no layout is persisted when empty.
+		savedBackupDir string
+		legacy         bool
+		newExists      bool
+		dryRun         bool
+		wantDir        string
+		wantMoved      bool
+	}{
+		{name: "nothing to migrate", wantDir: "state"},
+		{name: "legacy backups", legacy: true, wantDir: "state", wantMoved: true},
+		{name: "legacy directory in the layout", savedBackupDir: legacyBackupDir, legacy: true, wantDir: "state", wantMoved: true},
+		{name: "legacy directory in the layout already moved", savedBackupDir: legacyBackupDir, wantDir: "state"},
+		{name: "custom directory in the layout", savedBackupDir: "custom", legacy: true, wantDir: "custom"},
+		{name: "both exist", legacy: true, newExists: true, wantDir: "state"},
+		{name: "dry run", legacy: true, dryRun: true, wantDir: legacyBackupDir},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			dir := filepath.Join(home, "state")
+			legacy := filepath.Join(home, legacyBackupDir)
+			i := &Installer{HomeDir: home, ConfigDir: filepath.Join(home, "config"), BackupDir: dir}
+			i.SetDryRun(tt.dryRun)
+			if tt.legacy {
+				writeTestFile(t, filepath.Join(legacy, "20200101T000000Z-1.0.0", backupInfoFile), "{}\n")
+			}
+			if tt.newExists {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var saved *Layout
+			if tt.savedBackupDir != "" {
+				saved = &Layout{BackupDir: filepath.Join(home, tt.savedBackupDir)}
+				i.BackupDir = saved.BackupDir
+			}
+
+			if err := i.migrateBackupDir(context.Background(), saved, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if want := filepath.Join(home, tt.wantDir); i.BackupDir != want {
+				t.Errorf("BackupDir = %s, want %s", i.BackupDir, want)
+			}
+			_, err := os.Stat(filepath.Join(dir, "20200101T000000Z-1.0.0", backupInfoFile))
+			if moved := err == nil; moved != tt.wantMoved {
+				t.Errorf("backups moved = %v, want %v", moved, tt.wantMoved)
+			}
+			if tt.wantMoved && saved != nil {
+				layout, err := i.loadLayout()
+				if err != nil || layout == nil || layout.BackupDir != dir {
+					t.Errorf("persisted layout = %+v, %v, want the backup directory %s", layout, err, dir)
+				}
+			}
+		})
+	}
+}
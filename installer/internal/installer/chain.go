@@ -0,0 +1,256 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// ChainConfig selects which displaced hooks run after the mamba-githook hook
+// of a hook type.
+type ChainConfig struct {
+	// Global runs the hook of the global core.hooksPath that was set before
+	// mamba-githook was installed.
+	Global bool `json:"global"`
+	// Local runs the hook of the repository's own .git/hooks directory.
+	Local bool `json:"local"`
+}
+
+// clientHookTypes are the hook types chained by default.
+var clientHookTypes = []string{
+	"applypatch-msg", "pre-applypatch", "post-applypatch",
+	"pre-commit", "pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+	"pre-rebase", "post-checkout", "post-merge", "pre-push", "post-rewrite",
+	"pre-auto-gc", "post-index-change", "sendemail-validate",
+}
+
+// stdinHookTypes are the hook types git feeds data on stdin.
+var stdinHookTypes = []string{
+	"pre-push", "post-rewrite", "reference-transaction",
+	"pre-receive", "post-receive", "proc-receive",
+}
+
+// gitHookTypes are all hook types accepted by --chain.
+var gitHookTypes = append(slices.Clone(clientHookTypes),
+	"reference-transaction", "fsmonitor-watchman", "p4-changelist", "p4-prepare-changelist", "p4-post-changelist", "p4-pre-submit",
+	"pre-receive", "update", "proc-receive", "post-receive", "post-update", "push-to-checkout",
+)
+
+// ParseChainSpecs parses --chain values of the form <hook>[=global|local|all|none].
+// The hook may be "all" for every client hook type, and the single value
+// "none" disables chaining.
+func ParseChainSpecs(specs []string) (map[string]ChainConfig, error) {
+	chain := make(map[string]ChainConfig)
+	for _, spec := range specs {
+		if spec == "none" {
+			clear(chain)
+			continue
+		}
+
+		hook, mode, found := strings.Cut(spec, "=")
+		if !found {
+			mode = "all"
+		}
+
+		var cfg ChainConfig
+		switch mode {
+		case "all":
+			cfg = ChainConfig{Global: true, Local: true}
+		case "global":
+			cfg = ChainConfig{Global: true}
+		case "local":
+			cfg = ChainConfig{Local: true}
+		case "none":
+		default:
+			return nil, fmt.Errorf("invalid chain mode %q in %q, expected global, local, all or none", mode, spec)
+		}
+
+		hooks := []string{hook}
+		if hook == "all" {
+			hooks = clientHookTypes
+		} else if !slices.Contains(gitHookTypes, hook) {
+			return nil, fmt.Errorf("unknown git hook type %q in %q", hook, spec)
+		}
+
+		for _, h := range hooks {
+			if cfg == (ChainConfig{}) {
+				delete(chain, h)
+			} else {
+				chain[h] = cfg
+			}
+		}
+	}
+	return chain, nil
+}
+
+// chain returns the effective chaining configuration, nothing is chained
+// unless configured.
+func (i *Installer) chain() map[string]ChainConfig {
+	if i.Chain != nil {
+		return i.Chain
+	}
+	return map[string]ChainConfig{}
+}
+
+// defaultChain returns the chaining configuration used when none is
+// configured: every client hook chains the repository's own .git/hooks, e.g.
+// those of Git LFS, and the global hooks path displaced by the installation
+// if there was one.
+func defaultChain(previousHooksPath string) map[string]ChainConfig {
+	chain := make(map[string]ChainConfig)
+	for _, hook := range clientHookTypes {
+		chain[hook] = ChainConfig{Global: previousHooksPath != "", Local: true}
+	}
+	return chain
+}
+
+// pipelineHooks returns the hook types the payload has a mamba-githook hook
+// for.
+func (i *Installer) pipelineHooks() ([]string, error) {
+	paths, err := fs.Glob(i.SrcFS, "src/hooks/*")
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]string, 0, len(paths))
+	for _, p := range paths {
+		hooks = append(hooks, path.Base(p))
+	}
+	return hooks, nil
+}
+
+// hookEnabled reports whether the mamba-githook hook of a hook type runs.
+func (i *Installer) hookEnabled(hook string) bool {
+	return i.Hooks == nil || slices.Contains(i.Hooks, hook)
+}
+
+// usesEntrypoints reports whether core.hooksPath points at the generated
+// entrypoints, which is needed to chain hooks or to enable only some hooks.
+func (i *Installer) usesEntrypoints() bool {
+	return len(i.chain()) > 0 || i.Hooks != nil
+}
+
+// chainsGlobal reports whether any hook type chains the previous global
+// hooks directory.
+func (i *Installer) chainsGlobal() bool {
+	for _, cfg := range i.chain() {
+		if cfg.Global {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Installer) chainDir() string {
+	return filepath.Join(i.TargetDir, "chain")
+}
+
+var entrypointTemplate = template.Must(template.New("entrypoint").Parse(`#!/bin/sh
+# Generated by mamba-githook-installer. Do not edit, changes are overwritten.
+#
+# Runs the mamba-githook {{.Hook}} hook, then the {{.Hook}} hooks it displaced.
+hook_name={{.Hook}}
+pipeline_hook={{.Pipeline}}
+global_hooks_dir={{.GlobalDir}}
+chain_local={{.Local}}
+{{if .Stdin}}
+# Git passes data on stdin to this hook, keep a copy for every hook run.
+stdin_file=$(mktemp) || exit 1
+trap 'rm -f "${stdin_file}"' EXIT
+cat >"${stdin_file}"
+{{end}}
+run_hook() {
+  hook="$1"
+  shift
+  if [ ! -f "${hook}" ] || [ ! -x "${hook}" ]; then
+    return 0
+  fi
+  {{if .Stdin}}"${hook}" "$@" <"${stdin_file}"{{else}}"${hook}" "$@"{{end}}
+}
+
+run_hook "${pipeline_hook}" "$@" || exit $?
+
+if [ -n "${global_hooks_dir}" ]; then
+  run_hook "${global_hooks_dir}/${hook_name}" "$@" || exit $?
+fi
+
+if [ "${chain_local}" = 1 ]; then
+  git_dir=$(git rev-parse --git-common-dir 2>/dev/null) || git_dir=""
+  if [ -n "${git_dir}" ]; then
+    run_hook "${git_dir}/hooks/${hook_name}" "$@" || exit $?
+  fi
+fi
+exit 0
+`))
+
+// writeChainEntrypoints generates an entrypoint per hook type into the chain
+// directory. Each entrypoint runs the mamba-githook hook, if there is one and
+// it is enabled, followed by the displaced hooks selected for that hook type.
+func (i *Installer) writeChainEntrypoints(ctx context.Context, previousHooksPath string) ([]ManifestFile, error) {
+	if !i.usesEntrypoints() {
+		return nil, nil
+	}
+	chain := i.chain()
+
+	pipelineHooks, err := i.pipelineHooks()
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]string, 0, len(chain)+len(pipelineHooks))
+	for hook := range chain {
+		hooks = append(hooks, hook)
+	}
+	for _, hook := range pipelineHooks {
+		if !slices.Contains(hooks, hook) && i.hookEnabled(hook) {
+			hooks = append(hooks, hook)
+		}
+	}
+	slices.Sort(hooks)
+
+	if err := i.mkdirAll(ctx, i.chainDir()); err != nil {
+		return nil, err
+	}
+
+	var files []ManifestFile
+	for _, hook := range hooks {
+		cfg := chain[hook]
+		data := struct {
+			Hook, Pipeline, GlobalDir string
+			Local                     int
+			Stdin                     bool
+		}{
+			Hook:  hook,
+			Stdin: slices.Contains(stdinHookTypes, hook),
+		}
+		if slices.Contains(pipelineHooks, hook) && i.hookEnabled(hook) {
+			data.Pipeline = singleQuote(i.unrooted(filepath.Join(i.TargetDir, "hooks", hook)), posixQuoteEscape)
+		} else {
+			data.Pipeline = `""`
+		}
+		if cfg.Global && previousHooksPath != "" {
+			data.GlobalDir = singleQuote(previousHooksPath, posixQuoteEscape)
+		} else {
+			data.GlobalDir = `""`
+		}
+		if cfg.Local {
+			data.Local = 1
+		}
+
+		var buf bytes.Buffer
+		if err := entrypointTemplate.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		file, err := i.writeFile(ctx, filepath.Join(i.chainDir(), hook), &buf, 0755, "generated")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
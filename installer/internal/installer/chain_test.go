@@ -0,0 +1,109 @@
+package installer
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseChainSpecs(t *testing.T) {
+	allClient := func(cfg ChainConfig) map[string]ChainConfig {
+		chain := make(map[string]ChainConfig)
+		for _, hook := range clientHookTypes {
+			chain[hook] = cfg
+		}
+		return chain
+	}
+
+	tests := []struct {
+		name    string
+		specs   []string
+		want    map[string]ChainConfig
+		wantErr bool
+	}{
+		{
+			name:  "hook without mode chains all",
+			specs: []string{"pre-commit"},
+			want:  map[string]ChainConfig{"pre-commit": {Global: true, Local: true}},
+		},
+		{
+			name:  "modes",
+			specs: []string{"pre-commit=global", "pre-push=local", "commit-msg=all"},
+			want: map[string]ChainConfig{
+				"pre-commit": {Global: true},
+				"pre-push":   {Local: true},
+				"commit-msg": {Global: true, Local: true},
+			},
+		},
+		{
+			name:  "all client hooks",
+			specs: []string{"all=global"},
+			want:  allClient(ChainConfig{Global: true}),
+		},
+		{
+			name:  "later spec disables a hook",
+			specs: []string{"all=local", "pre-commit=none"},
+			want: func() map[string]ChainConfig {
+				chain := allClient(ChainConfig{Local: true})
+				delete(chain, "pre-commit")
+				return chain
+			}(),
+		},
+		{
+			name:  "none clears earlier specs",
+			specs: []string{"pre-commit", "none", "pre-push=global"},
+			want:  map[string]ChainConfig{"pre-push": {Global: true}},
+		},
+		{
+			name:  "server hook",
+			specs: []string{"pre-receive=global"},
+			want:  map[string]ChainConfig{"pre-receive": {Global: true}},
+		},
+		{
+			name:    "unknown hook",
+			specs:   []string{"pre-comit"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			specs:   []string{"pre-commit=both"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChainSpecs(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChainSpecs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("ParseChainSpecs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultChain(t *testing.T) {
+	tests := []struct {
+		name              string
+		previousHooksPath string
+		want              ChainConfig
+	}{
+		{name: "nothing displaced", want: ChainConfig{Local: true}},
+		{name: "displaced global hooks", previousHooksPath: "/old/hooks", want: ChainConfig{Global: true, Local: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := defaultChain(tt.previousHooksPath)
+			if len(got) != len(clientHookTypes) {
+				t.Fatalf("defaultChain() chains %d hooks, want every client hook", len(got))
+			}
+			for hook, cfg := range got {
+				if cfg != tt.want {
+					t.Errorf("defaultChain()[%s] = %+v, want %+v", hook, cfg, tt.want)
+				}
+			}
+		})
+	}
+}
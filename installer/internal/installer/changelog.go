@@ -0,0 +1,97 @@
+package installer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// changelogFile is the Debian changelog of the release, copied into the
+// embedded payload by the build.
+const changelogFile = "src/changelog"
+
+var changelogHeaderPattern = regexp.MustCompile(`^\S+ \(([^)]+)\)`)
+
+// changelogEntry is the changelog of a single release.
+type changelogEntry struct {
+	Version string
+	Lines   []string
+}
+
+// parseChangelog reads the entries of a Debian changelog, newest first.
+func parseChangelog(r io.Reader) ([]changelogEntry, error) {
+	var entries []changelogEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := changelogHeaderPattern.FindStringSubmatch(line); match != nil {
+			entries = append(entries, changelogEntry{Version: match[1]})
+			continue
+		}
+		// Skip the trailer, blank lines and anything before the first entry
+		if len(entries) == 0 || strings.HasPrefix(line, " -- ") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := &entries[len(entries)-1]
+		entry.Lines = append(entry.Lines, strings.TrimPrefix(line, "  "))
+	}
+	return entries, scanner.Err()
+}
+
+// changelogBetween returns the embedded changelog entries newer than from and
+// up to and including to. It returns nil when the payload has no changelog.
+func (i *Installer) changelogBetween(from, to string) ([]changelogEntry, error) {
+	f, err := i.SrcFS.Open(changelogFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := parseChangelog(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded changelog: %w", err)
+	}
+
+	var excerpt []changelogEntry
+	for _, entry := range entries {
+		newer, err := compareVersions(entry.Version, from)
+		if err != nil {
+			continue
+		}
+		older, err := compareVersions(entry.Version, to)
+		if err != nil {
+			continue
+		}
+		if newer > 0 && older <= 0 {
+			excerpt = append(excerpt, entry)
+		}
+	}
+	return excerpt, nil
+}
+
+// printChangelog writes the changes between two versions to w.
+func (i *Installer) printChangelog(w io.Writer, from, to string) error {
+	entries, err := i.changelogBetween(from, to)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "Changes from %s to %s:\n", from, to)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "\n%s\n", entry.Version)
+		for _, line := range entry.Lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+// backupChecksumsFile lists the SHA-256 of every file of a backup in the
+// format of sha256sum, so it can also be checked with "sha256sum -c".
+const backupChecksumsFile = "SHA256SUMS"
+
+// backupInfoChecksumsFile records the SHA-256 of the backup information of
+// every generation. It is kept in StateDir, outside of the backups, so that
+// editing a generation cannot also update the checksum it is verified with.
+const backupInfoChecksumsFile = "backup-info.sha256"
+
+// ErrCorruptBackup is returned when a backup does not match its checksums.
+var ErrCorruptBackup = errors.New("backup is corrupt")
+
+// errNoChecksums is returned for backups made before checksums were added.
+var errNoChecksums = errors.New("backup has no checksums")
+
+// BackupVerification is the result of verifying one backup generation.
+type BackupVerification struct {
+	ID       string   `json:"id" yaml:"id"`
+	OK       bool     `json:"ok" yaml:"ok"`
+	Verified bool     `json:"verified" yaml:"verified"`
+	Problems []string `json:"problems,omitempty" yaml:"problems,omitempty"`
+}
+
+// writeBackupChecksums writes the checksums of the files in a backup
+// generation directory and returns the checksum of the checksums file, which
+// is recorded in the backup information to detect tampering or truncation.
+func (i *Installer) writeBackupChecksums(ctx context.Context, dir string) (string, error) {
+	path := filepath.Join(dir, backupChecksumsFile)
+	if i.DryRun {
+		i.planFileWrite(path, 0, 0644)
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if relPath == backupInfoFile || relPath == backupChecksumsFile {
+			return nil
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", sum, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	if _, err := i.writeFile(ctx, path, &buf, 0644, "generated"); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (i *Installer) backupInfoChecksumsPath() string {
+	return filepath.Join(i.StateDir, backupInfoChecksumsFile)
+}
+
+// backupInfoChecksums reads the recorded checksums of the backup information
+// by backup ID.
+func (i *Installer) backupInfoChecksums() (map[string]string, error) {
+	sums := make(map[string]string)
+	data, err := os.ReadFile(i.backupInfoChecksumsPath())
+	if os.IsNotExist(err) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup checksums: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if sum, id, ok := strings.Cut(line, "  "); ok {
+			sums[id] = sum
+		}
+	}
+	return sums, nil
+}
+
+// recordBackupInfoChecksum records the checksum of the backup information of
+// a generation, an empty sum removes it.
+func (i *Installer) recordBackupInfoChecksum(ctx context.Context, id, sum string) error {
+	sums, err := i.backupInfoChecksums()
+	if err != nil {
+		return err
+	}
+	if sums[id] == sum {
+		return nil
+	}
+	if sum == "" {
+		delete(sums, id)
+	} else {
+		sums[id] = sum
+	}
+
+	var buf bytes.Buffer
+	for _, id := range slices.Sorted(maps.Keys(sums)) {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[id], id)
+	}
+	if err := i.mkdirAll(ctx, i.StateDir); err != nil {
+		return err
+	}
+	if _, err := i.writeFile(ctx, i.backupInfoChecksumsPath(), &buf, 0644, "generated"); err != nil {
+		return fmt.Errorf("failed to record backup checksum: %w", err)
+	}
+	return nil
+}
+
+// verifyBackupDir checks the files of a backup generation directory against
+// its checksums and returns the problems found. infoSum is the checksum
+// recorded for its backup information, empty if none is recorded.
+// errNoChecksums is returned when the backup cannot be verified, which is
+// only the case for backups without any recorded checksum.
+func verifyBackupDir(dir string, backup *BackupInfo, infoSum string) ([]string, error) {
+	if backup.Checksums == "" && infoSum == "" {
+		return nil, errNoChecksums
+	}
+
+	info, err := fileSHA256(filepath.Join(dir, backupInfoFile))
+	switch {
+	case os.IsNotExist(err):
+		return []string{backupInfoFile + " is missing"}, nil
+	case err != nil:
+		return nil, err
+	case infoSum == "":
+		return []string{"no checksum of " + backupInfoFile + " is recorded in " + backupInfoChecksumsFile}, nil
+	case info != infoSum:
+		return []string{backupInfoFile + " does not match the checksum recorded in " + backupInfoChecksumsFile}, nil
+	case backup.Checksums == "":
+		return []string{"no checksum of " + backupChecksumsFile + " is recorded in " + backupInfoFile}, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, backupChecksumsFile))
+	if os.IsNotExist(err) {
+		return []string{backupChecksumsFile + " is missing"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != backup.Checksums {
+		return []string{backupChecksumsFile + " does not match the checksum recorded in " + backupInfoFile}, nil
+	}
+
+	var problems []string
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		want, relPath, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			problems = append(problems, fmt.Sprintf("invalid line in %s: %q", backupChecksumsFile, scanner.Text()))
+			continue
+		}
+		listed[relPath] = true
+
+		got, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(relPath)))
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, "missing file: "+relPath)
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("unreadable file %s: %v", relPath, err))
+		case got != want:
+			problems = append(problems, "checksum mismatch: "+relPath)
+		}
+	}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !listed[relPath] && relPath != backupInfoFile && relPath != backupChecksumsFile {
+			problems = append(problems, "unexpected file: "+relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+// openBackup returns the directory holding the files of a backup, extracting
+// compressed backups into a temporary directory removed by cleanup. A
+// truncated or corrupt archive is reported in problems, the directory then
+// holds what could be extracted.
+func openBackup(backup *BackupInfo) (dir string, problems []string, cleanup func(), err error) {
+	if !backup.Compressed {
+		return backup.Path, nil, func() {}, nil
+	}
+
+	tempDir, err := os.MkdirTemp("", "mamba-githook-backup-")
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(tempDir) }
+	if err := extractArchive(backup.Path, tempDir); err != nil {
+		problems = append(problems, fmt.Sprintf("archive is truncated or corrupt: %v", err))
+	}
+	return tempDir, problems, cleanup, nil
+}
+
+// VerifyBackup checks a backup generation against its checksums.
+func (i *Installer) VerifyBackup(ctx context.Context, id string) (*BackupVerification, error) {
+	backup, err := i.findBackup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, problems, cleanup, err := openBackup(backup)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	sums, err := i.backupInfoChecksums()
+	if err != nil {
+		return nil, err
+	}
+	result := &BackupVerification{ID: backup.ID, Verified: true}
+	found, err := verifyBackupDir(dir, backup, sums[backup.ID])
+	switch {
+	case errors.Is(err, errNoChecksums):
+		result.Verified = false
+	case err != nil:
+		return nil, err
+	}
+	result.Problems = append(problems, found...)
+	result.OK = len(result.Problems) == 0
+	return result, nil
+}
+
+// VerifyBackups verifies the given backup generations, or all of them when
+// ids is empty, and prints the results in the given output format: text,
+// json or yaml. ErrCorruptBackup is returned if any backup is corrupt.
+func (i *Installer) VerifyBackups(ctx context.Context, ids []string, output string, w io.Writer) error {
+	ctx = log.WithOperation(ctx, "backup-verify")
+	if len(ids) == 0 {
+		backups, err := i.Backups(ctx)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return fmt.Errorf("no backup found in %s", i.BackupDir)
+		}
+		for _, backup := range backups {
+			ids = append(ids, backup.ID)
+		}
+	}
+
+	results := []*BackupVerification{}
+	corrupt := false
+	for _, id := range ids {
+		result, err := i.VerifyBackup(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !result.OK {
+			corrupt = true
+		}
+		results = append(results, result)
+	}
+
+	if output == "text" {
+		for _, result := range results {
+			state := "ok"
+			switch {
+			case !result.OK:
+				state = "FAIL"
+			case !result.Verified:
+				state = "skip"
+			}
+			fmt.Fprintf(w, "[%4s] %s\n", state, result.ID)
+			if !result.Verified {
+				fmt.Fprintf(w, "       %s\n", errNoChecksums)
+			}
+			for _, problem := range result.Problems {
+				fmt.Fprintf(w, "       %s\n", problem)
+			}
+		}
+	} else if err := encodeOutput(w, output, results); err != nil {
+		return fmt.Errorf("failed to encode verification: %w", err)
+	}
+
+	if corrupt {
+		return ErrCorruptBackup
+	}
+	return nil
+}
+
+// checkBackup verifies a backup before it is restored. Corrupt backups are
+// refused unless Force is set.
+func (i *Installer) checkBackup(ctx context.Context, dir string, backup *BackupInfo, problems []string) error {
+	sums, err := i.backupInfoChecksums()
+	if err != nil {
+		return err
+	}
+	found, err := verifyBackupDir(dir, backup, sums[backup.ID])
+	if errors.Is(err, errNoChecksums) {
+		log.Ctx(ctx).Warn().Msgf("Backup %s has no checksums and cannot be verified", backup.ID)
+	} else if err != nil {
+		return err
+	}
+	problems = append(problems, found...)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	for _, problem := range problems {
+		log.Ctx(ctx).Warn().Msgf("Backup %s: %s", backup.ID, problem)
+	}
+	if !i.Force {
+		return fmt.Errorf("%w: %s failed verification, use --force to restore it anyway", ErrCorruptBackup, backup.ID)
+	}
+	log.Ctx(ctx).Warn().Msgf("Restoring corrupt backup %s", backup.ID)
+	return nil
+}
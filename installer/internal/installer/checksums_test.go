@@ -0,0 +1,179 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyBackupDir(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the backup in dir, infoSum is the checksum
+		// recorded for its backup information.
+		tamper       func(t *testing.T, dir string, infoSum *string)
+		wantProblems []string
+	}{
+		{
+			name: "intact",
+		},
+		{
+			name: "modified file",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				writeTestFile(t, filepath.Join(dir, "target", "hook"), "changed\n")
+			},
+			wantProblems: []string{"checksum mismatch: target/hook"},
+		},
+		{
+			name: "missing file",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				if err := os.Remove(filepath.Join(dir, "mamba-githook")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantProblems: []string{"missing file: mamba-githook"},
+		},
+		{
+			name: "unexpected file",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				writeTestFile(t, filepath.Join(dir, "target", "extra"), "extra\n")
+			},
+			wantProblems: []string{"unexpected file: target/extra"},
+		},
+		{
+			name: "edited checksums",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				writeTestFile(t, filepath.Join(dir, backupChecksumsFile), "")
+			},
+			wantProblems: []string{backupChecksumsFile + " does not match the checksum recorded in " + backupInfoFile},
+		},
+		{
+			name: "edited checksums and backup information",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				writeTestFile(t, filepath.Join(dir, backupChecksumsFile), "")
+				writeTestBackupInfo(t, dir, BackupInfo{ID: "test", Checksums: sha256Hex("")})
+			},
+			wantProblems: []string{backupInfoFile + " does not match the checksum recorded in " + backupInfoChecksumsFile},
+		},
+		{
+			name: "checksums removed from backup information",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				writeTestFile(t, filepath.Join(dir, "target", "hook"), "changed\n")
+				writeTestBackupInfo(t, dir, BackupInfo{ID: "test"})
+			},
+			wantProblems: []string{backupInfoFile + " does not match the checksum recorded in " + backupInfoChecksumsFile},
+		},
+		{
+			name: "checksums removed from recorded backup information",
+			tamper: func(t *testing.T, dir string, infoSum *string) {
+				writeTestFile(t, filepath.Join(dir, "target", "hook"), "changed\n")
+				*infoSum = writeTestBackupInfo(t, dir, BackupInfo{ID: "test"})
+			},
+			wantProblems: []string{"no checksum of " + backupChecksumsFile + " is recorded in " + backupInfoFile},
+		},
+		{
+			name: "backup information not recorded",
+			tamper: func(t *testing.T, dir string, infoSum *string) {
+				*infoSum = ""
+			},
+			wantProblems: []string{"no checksum of " + backupInfoFile + " is recorded in " + backupInfoChecksumsFile},
+		},
+		{
+			name: "missing backup information",
+			tamper: func(t *testing.T, dir string, _ *string) {
+				if err := os.Remove(filepath.Join(dir, backupInfoFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantProblems: []string{backupInfoFile + " is missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, "target", "hook"), "hook\n")
+			writeTestFile(t, filepath.Join(dir, "mamba-githook"), "binary\n")
+
+			i := &Installer{}
+			checksums, err := i.writeBackupChecksums(context.Background(), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backup := BackupInfo{ID: "test", Checksums: checksums}
+			infoSum := writeTestBackupInfo(t, dir, backup)
+
+			if tt.tamper != nil {
+				tt.tamper(t, dir, &infoSum)
+			}
+			// Verify what is on disk, as restore does
+			if data, err := os.ReadFile(filepath.Join(dir, backupInfoFile)); err == nil {
+				backup = BackupInfo{}
+				if err := json.Unmarshal(data, &backup); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			problems, err := verifyBackupDir(dir, &backup, infoSum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(problems, "\n") != strings.Join(tt.wantProblems, "\n") {
+				t.Errorf("verifyBackupDir() = %q, want %q", problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestVerifyBackupDirWithoutChecksums(t *testing.T) {
+	if _, err := verifyBackupDir(t.TempDir(), &BackupInfo{ID: legacyBackupID}, ""); !errors.Is(err, errNoChecksums) {
+		t.Errorf("verifyBackupDir() error = %v, want %v", err, errNoChecksums)
+	}
+}
+
+func TestRecordBackupInfoChecksum(t *testing.T) {
+	ctx := context.Background()
+	i := &Installer{StateDir: filepath.Join(t.TempDir(), "state")}
+
+	for _, step := range []struct{ id, sum string }{
+		{"b", "2"}, {"a", "1"}, {"b", "3"}, {"a", ""}, {"missing", ""},
+	} {
+		if err := i.recordBackupInfoChecksum(ctx, step.id, step.sum); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sums, err := i.backupInfoChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums["b"] != "3" {
+		t.Errorf("backupInfoChecksums() = %v, want only b", sums)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestBackupInfo writes the backup information into dir and returns
+// its checksum.
+func writeTestBackupInfo(t *testing.T, dir string, info BackupInfo) string {
+	t.Helper()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, backupInfoFile), string(data)+"\n")
+	return sha256Hex(string(data) + "\n")
+}
@@ -1,6 +1,10 @@
 package installer
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -8,6 +12,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/aydabd/mamba-githook/installer/internal/log"
@@ -24,56 +29,220 @@ func detectShell() string {
 	return filepath.Base(shell)
 }
 
-func (i *Installer) getShellConfigFile() string {
-	homeDir, _ := os.UserHomeDir()
+// hooksDir returns the directory the global core.hooksPath points at: the
+// generated entrypoints when chaining is enabled or only some hooks are,
+// otherwise the mamba-githook hooks themselves.
+func (i *Installer) hooksDir() string {
+	if i.usesEntrypoints() {
+		return i.chainDir()
+	}
+	return filepath.Join(i.TargetDir, "hooks")
+}
 
-	switch i.Shell {
-	case "bash":
-		return filepath.Join(homeDir, ".bashrc")
-	case "zsh":
-		return filepath.Join(homeDir, ".zshrc")
-	case "fish":
-		return filepath.Join(homeDir, ".config", "fish", "config.fish")
-	default:
-		return filepath.Join(homeDir, ".profile")
+// isOwnHooksPath reports whether a core.hooksPath value belongs to this
+// installation.
+func (i *Installer) isOwnHooksPath(path string) bool {
+	path = filepath.Clean(path)
+	return path == i.unrooted(filepath.Join(i.TargetDir, "hooks")) || path == i.unrooted(i.chainDir())
+}
+
+// previousHooksPath returns the global core.hooksPath that the installation
+// replaces. When reinstalling over an existing installation the value
+// recorded by that installation is kept.
+func (i *Installer) previousHooksPath(ctx context.Context, installed *Manifest) (string, error) {
+	current, ok, err := gitConfigGet(ctx, i.gitScope(), "core.hooksPath")
+	if err != nil {
+		return "", err
 	}
+
+	switch {
+	case ok && i.isOwnHooksPath(current):
+		if installed != nil {
+			return installed.PreviousHooksPath, nil
+		}
+		return "", nil
+	case ok && current != "" && (i.Chain == nil || i.chainsGlobal()):
+		log.Ctx(ctx).Info().Msgf("Global core.hooksPath was set to %s, its hooks are chained after the mamba-githook hooks", current)
+	case ok && current != "":
+		log.Ctx(ctx).Warn().Msgf("Global core.hooksPath is already set to %s, the hooks in it will no longer run. "+
+			"Install with --chain to keep running them. The previous value is restored when mamba-githook is uninstalled", current)
+	}
+	return current, nil
 }
 
-func (i *Installer) setupGitHooks() error {
-	log.Info().Msg("Setting up Git hooks directory")
+// setupGitHooks points the global core.hooksPath at the mamba-githook hooks.
+// With the repo hooks scope it only explains how to enable them per
+// repository.
+func (i *Installer) setupGitHooks(ctx context.Context) error {
+	if i.HooksScope == HooksScopeRepo {
+		log.Ctx(ctx).Info().Msgf("Global core.hooksPath is left untouched, enable the hooks in a repository with: git config core.hooksPath %s", i.unrooted(i.hooksDir()))
+		return nil
+	}
+
+	log.Ctx(ctx).Info().Msg("Setting up Git hooks directory")
 
-	cmd := exec.Command("git", "config", "--global", "core.hooksPath", filepath.Join(i.TargetDir, "hooks"))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set global Git hooks path: %w", err)
+	if err := i.setGitConfig(ctx, i.gitScope(), "core.hooksPath", i.unrooted(i.hooksDir())); err != nil {
+		return fmt.Errorf("failed to set Git hooks path: %w", err)
 	}
 
-	log.Info().Msg("Git hooks sets: " + filepath.Join(i.TargetDir, "hooks"))
+	log.Ctx(ctx).Info().Msg("Git hooks sets: " + i.unrooted(i.hooksDir()))
+
+	// The global value of a user takes precedence over the system one
+	if i.System && i.Root == "" {
+		if global, ok, err := gitConfigGet(ctx, "global", "core.hooksPath"); err == nil && ok {
+			log.Ctx(ctx).Warn().Msgf("Your global core.hooksPath %s overrides the system value for your user", global)
+		}
+	}
 	return nil
 }
 
-func (i *Installer) restoreGitHooks() error {
-	log.Info().Msg("Restoring original Git hooks configuration")
+// restoreGitHooks sets the global core.hooksPath back to the value it had
+// before installation, or unsets it if there was none. A value changed by
+// the user after installation is left untouched.
+func (i *Installer) restoreGitHooks(ctx context.Context, previous string) error {
+	log.Ctx(ctx).Info().Msg("Restoring original Git hooks configuration")
+
+	current, ok, err := gitConfigGet(ctx, i.gitScope(), "core.hooksPath")
+	if err != nil {
+		return err
+	}
+	if ok && !i.isOwnHooksPath(current) {
+		log.Ctx(ctx).Warn().Msgf("Global core.hooksPath was changed to %s after installation, leaving it untouched", current)
+		return nil
+	}
+
+	if previous != "" {
+		if err := i.setGitConfig(ctx, i.gitScope(), "core.hooksPath", previous); err != nil {
+			return fmt.Errorf("failed to restore Git hooks path: %w", err)
+		}
+		log.Ctx(ctx).Info().Msg("Original Git hooks configuration restored: " + previous)
+		return nil
+	}
 
-	cmd := exec.Command("git", "config", "--global", "--unset", "core.hooksPath")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to unset global Git hooks path: %w", err)
+	if err := i.unsetGitConfig(ctx, i.gitScope(), "core.hooksPath"); err != nil {
+		return fmt.Errorf("failed to unset Git hooks path: %w", err)
 	}
 
-	log.Info().Msg("Original Git hooks configuration restored")
+	log.Ctx(ctx).Info().Msg("Original Git hooks configuration restored")
+	return nil
+}
+
+// Scopes of core.hooksPath.
+const (
+	// HooksScopeGlobal sets core.hooksPath in the global, or for system
+	// installations the system, git config.
+	HooksScopeGlobal = "global"
+	// HooksScopeRepo leaves core.hooksPath to be set in each repository.
+	HooksScopeRepo = "repo"
+)
+
+// gitConfigGet returns the value of key in the given git config scope
+// (global, system, local or file:<path>). ok is false if the key is not set.
+func gitConfigGet(ctx context.Context, scope, key string) (value string, ok bool, err error) {
+	output, err := gitConfigCommand(ctx, scope, key).Output()
+	err = commandError(ctx, err)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get git config %s: %w", key, err)
+	}
+	return strings.TrimSpace(string(output)), true, nil
+}
+
+// setGitConfig sets a git config entry, journaling its previous value.
+func (i *Installer) setGitConfig(ctx context.Context, scope, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "git-set", Target: strings.Join(append(gitScopeArgs(scope), key), " "), Detail: value})
+		return nil
+	}
+	if file, ok := strings.CutPrefix(scope, "file:"); ok {
+		if err := i.mkdirAll(ctx, filepath.Dir(file)); err != nil {
+			return err
+		}
+	}
+	previous, _, err := gitConfigGet(ctx, scope, key)
+	if err != nil {
+		return err
+	}
+	if err := i.journalGitConfig(ctx, scope, key); err != nil {
+		return err
+	}
+	if err := gitConfigSet(ctx, scope, key, value); err != nil {
+		return err
+	}
+	i.auditGitConfig(log.GitConfigChange{Scope: scope, Key: key, Value: value, Previous: previous})
+	return nil
+}
+
+// unsetGitConfig unsets a git config entry, journaling its previous value.
+func (i *Installer) unsetGitConfig(ctx context.Context, scope, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if i.DryRun {
+		if _, ok, err := gitConfigGet(ctx, scope, key); err == nil && ok {
+			i.plan.add(PlanAction{Op: "git-unset", Target: strings.Join(append(gitScopeArgs(scope), key), " ")})
+		}
+		return nil
+	}
+	previous, ok, err := gitConfigGet(ctx, scope, key)
+	if err != nil || !ok {
+		return err
+	}
+	if err := i.journalGitConfig(ctx, scope, key); err != nil {
+		return err
+	}
+	if err := gitConfigUnset(ctx, scope, key); err != nil {
+		return err
+	}
+	i.auditGitConfig(log.GitConfigChange{Scope: scope, Key: key, Previous: previous, Unset: true})
 	return nil
 }
 
-func (i *Installer) createDirectories() error {
+func gitConfigCommand(ctx context.Context, scope string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "git", append(append([]string{"config"}, gitScopeArgs(scope)...), args...)...)
+}
+
+// commandError reports the cancellation of ctx instead of the failure of the
+// command it killed.
+func commandError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func gitConfigSet(ctx context.Context, scope, key, value string) error {
+	return commandError(ctx, gitConfigCommand(ctx, scope, key, value).Run())
+}
+
+func gitConfigUnset(ctx context.Context, scope, key string) error {
+	err := commandError(ctx, gitConfigCommand(ctx, scope, "--unset", key).Run())
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 5 {
+		// The key was not set
+		return nil
+	}
+	return err
+}
+
+func (i *Installer) createDirectories(ctx context.Context) error {
 	dirs := []string{i.TargetDir, i.BinDir}
 	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err := i.mkdirAll(ctx, dir); err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
 	return nil
 }
 
-func (i *Installer) copyDir(src, dst string) error {
+// copyDir copies the directory tree src to dst, keeping file modes.
+func (i *Installer) copyDir(ctx context.Context, src, dst string) error {
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -87,97 +256,101 @@ func (i *Installer) copyDir(src, dst string) error {
 		dstPath := filepath.Join(dst, relPath)
 
 		if info.IsDir() {
-			return os.MkdirAll(dstPath, info.Mode())
+			if i.DryRun {
+				return i.mkdirAll(ctx, dstPath)
+			}
+			return os.MkdirAll(dstPath, info.Mode().Perm())
 		}
 
-		return i.copyFile(path, dstPath)
+		return i.copyFile(ctx, path, dstPath)
 	})
 }
 
-func (i *Installer) copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
+// copyFile copies src to dst, keeping the file mode of src.
+func (i *Installer) copyFile(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	defer in.Close()
+	if i.DryRun {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		i.planFileWrite(dst, info.Size(), info.Mode())
+		return nil
+	}
+	return copyFileMode(src, dst)
+}
 
-	out, err := os.Create(dst)
+// copyFileMode copies src to dst, keeping the file mode of src, and syncs
+// dst to disk.
+func copyFileMode(src, dst string) error {
+	info, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, in)
+	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	return out.Close()
-}
-
-func (i *Installer) appendLinesToFile(filename string, lines []string) error {
-	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer out.Close()
 
-	for _, line := range lines {
-		if _, err := f.WriteString(line + "\n"); err != nil {
-			return err
-		}
+	if _, err := io.Copy(out, in); err != nil {
+		return err
 	}
-
-	return nil
-}
-
-func (i *Installer) removeLinesByContent(filename string, contents []string) error {
-	input, err := os.ReadFile(filename)
-	if err != nil {
+	// OpenFile does not change the mode of an existing file and the umask
+	// applies to new ones
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
 		return err
 	}
-
-	lines := strings.Split(string(input), "\n")
-
-	var newLines []string
-	for _, line := range lines {
-		keep := true
-		for _, content := range contents {
-			if strings.Contains(line, content) {
-				keep = false
-				break
-			}
-		}
-		if keep {
-			newLines = append(newLines, line)
-		}
+	if err := out.Sync(); err != nil {
+		return err
 	}
-
-	output := strings.Join(newLines, "\n")
-	return os.WriteFile(filename, []byte(output), 0644)
+	return out.Close()
 }
 
-func (i *Installer) checkGitHooks() error {
-	cmd := exec.Command("git", "config", "--global", "core.hooksPath")
-	output, err := cmd.Output()
+// checkGitHooks reports the effective global and local core.hooksPath. The
+// local value is read from the repository of the working directory, if any.
+func (i *Installer) checkGitHooks(ctx context.Context) (GitHooksStatus, error) {
+	status := GitHooksStatus{Expected: i.unrooted(i.hooksDir())}
+
+	hooksPath, ok, err := gitConfigGet(ctx, i.gitScope(), "core.hooksPath")
 	if err != nil {
-		return fmt.Errorf("failed to get Git hooks path: %w", err)
+		return status, fmt.Errorf("failed to get Git hooks path: %w", err)
 	}
+	status.Global = hooksPath
+	status.GlobalCorrect = ok && filepath.Clean(hooksPath) == filepath.Clean(status.Expected)
 
-	hooksPath := strings.TrimSpace(string(output))
-	expectedPath := filepath.Join(i.TargetDir, "hooks")
-
-	if hooksPath != expectedPath {
-		log.Warn().Msgf("Git hooks path is not set correctly. Expected: %s, Got: %s", expectedPath, hooksPath)
+	// Outside of a repository git fails, which simply means no local value.
+	if localPath, ok, err := gitConfigGet(ctx, "local", "core.hooksPath"); err == nil && ok {
+		status.Local = localPath
 	}
 
-	return nil
+	return status, nil
 }
 
 // copyProjectFiles copies the project files from the embedded filesystem to the target directory
-func (i *Installer) copyProjectFiles() error {
-	log.Info().Msg("Copying mamba-githook files")
+// and returns the manifest entries of every file written. Files of the
+// installed manifest that were modified locally are merged with mergeFile.
+func (i *Installer) copyProjectFiles(ctx context.Context, installed *Manifest) ([]ManifestFile, error) {
+	log.Ctx(ctx).Info().Msg("Copying mamba-githook files")
+
+	previous := make(map[string]*ManifestFile)
+	if installed != nil {
+		for n, file := range installed.Files {
+			previous[file.Path] = &installed.Files[n]
+		}
+	}
 
+	var files []ManifestFile
+	var conflicts []string
 	err := fs.WalkDir(i.SrcFS, "src", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -187,102 +360,263 @@ func (i *Installer) copyProjectFiles() error {
 		if err != nil {
 			return err
 		}
+		if i.TemplateDir != "" && filepath.ToSlash(relPath) == "templates" {
+			return fs.SkipDir
+		}
 
-		var dstPath string
-		var mode os.FileMode = 0644 // Default mode for regular files
-
-		switch {
-		case relPath == "mamba-githook":
-			dstPath = filepath.Join(i.BinDir, "mamba-githook")
-			mode = 0755 // Executable
-		case relPath == "mamba-githook.1":
-			if i.OS == "windows" {
-				// Skip man page on Windows
-				return nil
-			}
-			manDir, err := i.getManPageDir()
-			if err != nil {
-				return err
-			}
-			dstPath = filepath.Join(manDir, "mamba-githook.1")
-		case strings.HasPrefix(relPath, "hooks/"):
-			dstPath = filepath.Join(i.TargetDir, relPath)
-			mode = 0755 // Executable
-		default:
-			dstPath = filepath.Join(i.TargetDir, relPath)
+		dstPath, mode, ok, err := i.destinationFor(relPath)
+		if err != nil || !ok {
+			return err
 		}
 
 		if d.IsDir() {
-			return os.MkdirAll(dstPath, 0755)
+			return i.mkdirAll(ctx, dstPath)
+		}
+		if err := i.mkdirAll(ctx, filepath.Dir(dstPath)); err != nil {
+			return err
 		}
 
-		srcFile, err := i.SrcFS.Open(path)
+		merged, conflict, err := i.mergeFile(ctx, path, dstPath, mode, previous[dstPath])
 		if err != nil {
 			return err
 		}
-		defer srcFile.Close()
+		if conflict {
+			conflicts = append(conflicts, dstPath)
+		}
+		files = append(files, merged...)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy mamba-githook files: %w", err)
+	}
 
-		dstFile, err := os.CreateTemp(filepath.Dir(dstPath), "temp-*")
+	if i.TemplateDir != "" {
+		templates, templateConflicts, err := i.copyTemplates(ctx, previous)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to copy templates from %s: %w", i.TemplateDir, err)
 		}
-		tempPath := dstFile.Name()
-		defer os.Remove(tempPath) // Clean up in case of failure
+		files = append(files, templates...)
+		conflicts = append(conflicts, templateConflicts...)
+	}
+
+	if len(conflicts) > 0 {
+		log.Ctx(ctx).Warn().Msgf("%d locally modified files changed in this version, review and merge the %s files: %s",
+			len(conflicts), newFileSuffix, strings.Join(conflicts, ", "))
+	}
 
-		_, err = io.Copy(dstFile, srcFile)
+	log.Ctx(ctx).Info().Msg("Mamba-githook files copied successfully")
+	return files, nil
+}
+
+// copyTemplates installs the project templates of TemplateDir in place of
+// the embedded ones, merging local modifications like the embedded files.
+// previous maps the installed manifest entries by path.
+func (i *Installer) copyTemplates(ctx context.Context, previous map[string]*ManifestFile) ([]ManifestFile, []string, error) {
+	dst := filepath.Join(i.TargetDir, "templates")
+	var files []ManifestFile
+	var conflicts []string
+	err := filepath.WalkDir(i.TemplateDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			dstFile.Close()
 			return err
 		}
-		dstFile.Close()
-
-		if err := os.Chmod(tempPath, mode); err != nil {
+		relPath, err := filepath.Rel(i.TemplateDir, path)
+		if err != nil {
 			return err
 		}
+		dstPath := filepath.Join(dst, relPath)
+		if d.IsDir() {
+			return i.mkdirAll(ctx, dstPath)
+		}
 
-		return os.Rename(tempPath, dstPath)
+		merged, conflict, err := i.mergeFile(ctx, templateSource+filepath.ToSlash(relPath), dstPath, 0644, previous[dstPath])
+		if err != nil {
+			return err
+		}
+		if conflict {
+			conflicts = append(conflicts, dstPath)
+		}
+		files = append(files, merged...)
+		return nil
 	})
+	return files, conflicts, err
+}
+
+// destinationFor maps a path relative to the embedded src directory to its
+// installed location and file mode. ok is false for files that are not
+// installed on the current OS.
+func (i *Installer) destinationFor(relPath string) (dst string, mode os.FileMode, ok bool, err error) {
+	relPath = filepath.ToSlash(relPath)
+
+	switch {
+	case relPath == "mamba-githook":
+		return filepath.Join(i.BinDir, "mamba-githook"), 0755, true, nil
+	case relPath == "mamba-githook.1":
+		if i.OS == "windows" {
+			// Skip man page on Windows
+			return "", 0, false, nil
+		}
+		return filepath.Join(i.ManDir, "mamba-githook.1"), 0644, true, nil
+	case strings.HasPrefix(relPath, "hooks/"):
+		return filepath.Join(i.TargetDir, relPath), 0755, true, nil
+	case "src/"+relPath == changelogFile:
+		// The changelog is only read by the installer itself
+		return "", 0, false, nil
+	default:
+		return filepath.Join(i.TargetDir, relPath), 0644, true, nil
+	}
+}
+
+// templateSource prefixes the source of files installed from TemplateDir,
+// followed by their slash separated path relative to it.
+const templateSource = "template:"
+
+// openSource opens the payload file src, which is either a path of the
+// embedded filesystem or a file of TemplateDir.
+func (i *Installer) openSource(src string) (io.ReadCloser, error) {
+	if relPath, ok := strings.CutPrefix(src, templateSource); ok {
+		if i.TemplateDir == "" {
+			return nil, fmt.Errorf("no template directory to read %s from", relPath)
+		}
+		return os.Open(filepath.Join(i.TemplateDir, filepath.FromSlash(relPath)))
+	}
+	return i.SrcFS.Open(src)
+}
 
+// installFile atomically writes the payload file src to dst with the given
+// mode and returns its manifest entry.
+func (i *Installer) installFile(ctx context.Context, src, dst string, mode os.FileMode) (ManifestFile, error) {
+	srcFile, err := i.openSource(src)
 	if err != nil {
-		return fmt.Errorf("failed to copy mamba-githook files: %w", err)
+		return ManifestFile{}, err
 	}
+	defer srcFile.Close()
 
-	log.Info().Msg("Mamba-githook files copied successfully")
-	return nil
+	return i.writeFile(ctx, dst, srcFile, mode, src)
 }
 
-// getManPageDir returns the appropriate directory for man pages based on the OS
-// and ensures that the directory exists
-func (i *Installer) getManPageDir() (string, error) {
-	var manDir string
-	switch i.OS {
-	case "darwin", "linux":
-		manDir = filepath.Join(i.HomeDir, ".local", "share", "man", "man1")
-	default:
-		// This shouldn't be reached due to the check in copyProjectFiles,
-		// but we'll provide a default just in case
-		manDir = filepath.Join(i.TargetDir, "man", "man1")
+// writeFile atomically writes the content of r to dst with the given mode
+// and returns its manifest entry. source records where the content came from.
+func (i *Installer) writeFile(ctx context.Context, dst string, r io.Reader, mode os.FileMode, source string) (ManifestFile, error) {
+	if err := ctx.Err(); err != nil {
+		return ManifestFile{}, err
+	}
+	if i.DryRun {
+		h := sha256.New()
+		size, err := io.Copy(h, r)
+		if err != nil {
+			return ManifestFile{}, err
+		}
+		i.planFileWrite(dst, size, mode)
+		return ManifestFile{Path: dst, SHA256: hex.EncodeToString(h.Sum(nil)), Mode: mode, Source: source}, nil
+	}
+
+	dstFile, err := os.CreateTemp(filepath.Dir(dst), "temp-*")
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	tempPath := dstFile.Name()
+	defer os.Remove(tempPath) // Clean up in case of failure
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dstFile, h), r)
+	if err == nil {
+		// Flush to disk so that a crash cannot leave a truncated file behind
+		// the rename
+		err = dstFile.Sync()
+	}
+	if err != nil {
+		dstFile.Close()
+		return ManifestFile{}, err
+	}
+	if err := dstFile.Close(); err != nil {
+		return ManifestFile{}, err
 	}
 
-	// Ensure the man page directory exists
-	if err := os.MkdirAll(manDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create man page directory %s: %w", manDir, err)
+	if err := os.Chmod(tempPath, mode); err != nil {
+		return ManifestFile{}, err
 	}
 
-	return manDir, nil
+	if err := i.journalFileWrite(ctx, dst); err != nil {
+		return ManifestFile{}, err
+	}
+	if err := os.Rename(tempPath, dst); err != nil {
+		return ManifestFile{}, err
+	}
+	i.auditFile(dst)
+
+	return ManifestFile{
+		Path:   dst,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Mode:   mode,
+		Source: source,
+	}, nil
 }
 
-func (i *Installer) setPermissions(dir string) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+// pruneEmptyDirs removes dir and all of its subdirectories that are empty.
+func (i *Installer) pruneEmptyDirs(ctx context.Context, dir string) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return os.Chmod(path, 0755)
-		}
-		if strings.HasSuffix(path, "mamba-githook") || strings.HasPrefix(filepath.Base(filepath.Dir(path)), "hooks") {
-			return os.Chmod(path, 0755)
+		if d.IsDir() {
+			dirs = append(dirs, path)
 		}
-		return os.Chmod(path, 0644)
+		return nil
 	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Walk order lists parents before children, so remove in reverse.
+	for j := len(dirs) - 1; j >= 0; j-- {
+		if err := i.removeIfEmpty(ctx, dirs[j]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSubPath reports whether path is dir or below it.
+func isSubPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// removeCreatedDirs removes the directories created by the installation
+// that are empty, children before their parents.
+func (i *Installer) removeCreatedDirs(ctx context.Context, dirs []string) error {
+	dirs = slices.Clone(dirs)
+	slices.Sort(dirs)
+	for j := len(dirs) - 1; j >= 0; j-- {
+		if _, err := os.Stat(dirs[j]); os.IsNotExist(err) {
+			continue
+		}
+		if err := i.removeIfEmpty(ctx, dirs[j]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIfEmpty removes dir if it is empty, in a dry run once the planned
+// removals are done.
+func (i *Installer) removeIfEmpty(ctx context.Context, dir string) error {
+	if i.DryRun && i.plan.deleted[dir] {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !i.DryRun || !i.plan.deleted[filepath.Join(dir, entry.Name())] {
+			return nil
+		}
+	}
+	return i.removeDir(ctx, dir)
 }
@@ -0,0 +1,397 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileName = "installer.yaml"
+	// configEnvPrefix prefixes the environment variables overriding the
+	// config file, e.g. MAMBA_GITHOOK_PREFIX for prefix.
+	configEnvPrefix = "MAMBA_GITHOOK_"
+)
+
+// Sources of configuration values, from the lowest to the highest
+// precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Config holds the settings of the installer configuration file. Empty
+// values are not set.
+type Config struct {
+	Prefix string   `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Shells []string `json:"shells,omitempty" yaml:"shells,omitempty"`
+	// Hooks lists the mamba-githook hooks to enable.
+	Hooks []string `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// MicromambaDir is the directory of the micromamba executable.
+	MicromambaDir string `json:"micromamba_dir,omitempty" yaml:"micromamba_dir,omitempty"`
+	// TemplateDir replaces the embedded project templates.
+	TemplateDir string `json:"template_dir,omitempty" yaml:"template_dir,omitempty"`
+	// HooksScope is global to set core.hooksPath for every repository or
+	// repo to leave it to each repository.
+	HooksScope string `json:"hooks_scope,omitempty" yaml:"hooks_scope,omitempty"`
+	// InstallMicromamba installs micromamba right after mamba-githook.
+	InstallMicromamba *bool  `json:"install_micromamba,omitempty" yaml:"install_micromamba,omitempty"`
+	LogFormat         string `json:"log_format,omitempty" yaml:"log_format,omitempty"`
+	// LogLevel is the minimum level of the logged messages.
+	LogLevel string `json:"log_level,omitempty" yaml:"log_level,omitempty"`
+	// LogFile receives every log message as JSON.
+	LogFile string `json:"log_file,omitempty" yaml:"log_file,omitempty"`
+}
+
+// configField is a single setting of a Config: a string, a list or a
+// boolean.
+type configField struct {
+	key     string
+	str     *string
+	list    *[]string
+	boolean **bool
+}
+
+func (c *Config) fields() []configField {
+	return []configField{
+		{key: "prefix", str: &c.Prefix},
+		{key: "shells", list: &c.Shells},
+		{key: "hooks", list: &c.Hooks},
+		{key: "micromamba_dir", str: &c.MicromambaDir},
+		{key: "template_dir", str: &c.TemplateDir},
+		{key: "hooks_scope", str: &c.HooksScope},
+		{key: "install_micromamba", boolean: &c.InstallMicromamba},
+		{key: "log_format", str: &c.LogFormat},
+		{key: "log_level", str: &c.LogLevel},
+		{key: "log_file", str: &c.LogFile},
+	}
+}
+
+func (f configField) isSet() bool {
+	switch {
+	case f.list != nil:
+		return len(*f.list) > 0
+	case f.boolean != nil:
+		return *f.boolean != nil
+	}
+	return *f.str != ""
+}
+
+func (f configField) value() any {
+	switch {
+	case f.list != nil:
+		return *f.list
+	case f.boolean != nil:
+		return *f.boolean != nil && **f.boolean
+	}
+	return *f.str
+}
+
+// copyFrom sets the field to the value of the same field of another Config.
+func (f configField) copyFrom(src configField) {
+	switch {
+	case f.list != nil:
+		*f.list = slices.Clone(*src.list)
+	case f.boolean != nil:
+		*f.boolean = *src.boolean
+	default:
+		*f.str = *src.str
+	}
+}
+
+// parse sets the field from an environment variable, lists are comma
+// separated.
+func (f configField) parse(s string) error {
+	switch {
+	case f.list != nil:
+		*f.list = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*f.list = append(*f.list, item)
+			}
+		}
+	case f.boolean != nil:
+		if s == "" {
+			*f.boolean = nil
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s, expected true or false", s, envName(f.key))
+		}
+		*f.boolean = &b
+	default:
+		*f.str = s
+	}
+	return nil
+}
+
+// envName returns the environment variable of a configuration key.
+func envName(key string) string {
+	return configEnvPrefix + strings.ToUpper(key)
+}
+
+// EnvValue returns the value of the configuration key set in the
+// environment, empty if it is not set.
+func EnvValue(key string) string {
+	return os.Getenv(envName(key))
+}
+
+// ConfigPath returns the default location of the installer configuration
+// file.
+func (i *Installer) ConfigPath() string {
+	return filepath.Join(i.ConfigDir, configFileName)
+}
+
+// LoadConfig reads an installer configuration file. A missing file yields
+// an empty configuration unless required is set.
+func LoadConfig(path string, required bool) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// SaveConfig writes cfg to an installer configuration file, so that the same
+// settings can be replayed by non-interactive installations.
+func (i *Installer) SaveConfig(ctx context.Context, path string, cfg *Config) (err error) {
+	ctx, end := i.startAudit(ctx, "save-config")
+	defer end(&err)
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := i.mkdirAll(ctx, filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if _, err := i.writeFile(ctx, path, bytes.NewReader(data), 0644, "generated"); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	log.Ctx(ctx).Info().Msgf("Configuration saved to %s", path)
+	return nil
+}
+
+// EffectiveConfig is the configuration merged from every source, with the
+// source of each value.
+type EffectiveConfig struct {
+	Config
+	// File is the configuration file that was read.
+	File    string
+	Sources map[string]string
+}
+
+// defaultConfig returns the values used when nothing is configured.
+func (i *Installer) defaultConfig() (*Config, error) {
+	hooks, err := i.pipelineHooks()
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		Shells:        i.shells(),
+		Hooks:         hooks,
+		MicromambaDir: i.defaultMicromambaDir(),
+		HooksScope:    HooksScopeGlobal,
+		LogFormat:     "console",
+		LogLevel:      "info",
+	}, nil
+}
+
+// ResolveConfig merges the defaults, the configuration file, the
+// MAMBA_GITHOOK_* environment variables and the flags, each taking
+// precedence over the previous ones.
+func (i *Installer) ResolveConfig(file *Config, path string, flags *Config) (*EffectiveConfig, error) {
+	defaults, err := i.defaultConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Config{}
+	for _, field := range env.fields() {
+		if value, ok := os.LookupEnv(envName(field.key)); ok {
+			if err := field.parse(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	eff := &EffectiveConfig{Config: *defaults, File: path, Sources: make(map[string]string)}
+	for _, field := range eff.fields() {
+		eff.Sources[field.key] = SourceDefault
+	}
+	for _, layer := range []struct {
+		source string
+		cfg    *Config
+	}{
+		{SourceFile, file},
+		{SourceEnv, env},
+		{SourceFlag, flags},
+	} {
+		fields := layer.cfg.fields()
+		for n, field := range eff.fields() {
+			if fields[n].isSet() {
+				field.copyFrom(fields[n])
+				eff.Sources[field.key] = layer.source
+			}
+		}
+	}
+	return eff, nil
+}
+
+// Explicit returns the values of cfg that are not defaults.
+func (cfg *EffectiveConfig) Explicit() *Config {
+	explicit := &Config{}
+	fields := cfg.fields()
+	for n, field := range explicit.fields() {
+		if cfg.Sources[field.key] != SourceDefault {
+			field.copyFrom(fields[n])
+		}
+	}
+	return explicit
+}
+
+// ApplyConfig configures the installer with the values of cfg that are not
+// defaults. The prefix is applied with ApplyLayout.
+func (i *Installer) ApplyConfig(cfg *EffectiveConfig) error {
+	configured := func(key string) bool { return cfg.Sources[key] != SourceDefault }
+
+	if configured("shells") {
+		for _, shell := range cfg.Shells {
+			if _, err := lookupShell(shell); err != nil {
+				return err
+			}
+		}
+		i.Shells = cfg.Shells
+	}
+	if configured("hooks") {
+		hooks, err := i.pipelineHooks()
+		if err != nil {
+			return err
+		}
+		for _, hook := range cfg.Hooks {
+			if !slices.Contains(hooks, hook) {
+				return fmt.Errorf("unknown mamba-githook hook %q, available hooks: %s", hook, strings.Join(hooks, ", "))
+			}
+		}
+		i.Hooks = cfg.Hooks
+	}
+	if configured("micromamba_dir") {
+		dir, err := filepath.Abs(cfg.MicromambaDir)
+		if err != nil {
+			return fmt.Errorf("invalid micromamba directory %q: %w", cfg.MicromambaDir, err)
+		}
+		i.MicromambaDir = dir
+	}
+	if configured("template_dir") {
+		dir, err := filepath.Abs(cfg.TemplateDir)
+		if err != nil {
+			return fmt.Errorf("invalid template directory %q: %w", cfg.TemplateDir, err)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("template directory %s does not exist", dir)
+		}
+		i.TemplateDir = dir
+	}
+	if configured("hooks_scope") {
+		if cfg.HooksScope != HooksScopeGlobal && cfg.HooksScope != HooksScopeRepo {
+			return fmt.Errorf("invalid hooks scope %q, expected %s or %s", cfg.HooksScope, HooksScopeGlobal, HooksScopeRepo)
+		}
+		i.HooksScope = cfg.HooksScope
+	}
+	if configured("install_micromamba") {
+		i.InstallMicromamba = cfg.InstallMicromamba != nil && *cfg.InstallMicromamba
+	}
+	if configured("log_format") {
+		if err := log.SetFormat(cfg.LogFormat); err != nil {
+			return err
+		}
+	}
+	if configured("log_level") {
+		if err := log.SetLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+	if configured("log_file") {
+		path, err := filepath.Abs(cfg.LogFile)
+		if err != nil {
+			return fmt.Errorf("invalid log file %q: %w", cfg.LogFile, err)
+		}
+		if err := log.SetFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigEntry is a configuration value and where it came from.
+type ConfigEntry struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  any    `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+	// Env is the environment variable overriding the value.
+	Env string `json:"env" yaml:"env"`
+}
+
+// PrintConfig prints the effective configuration and the source of each
+// value in the given output format: text, json or yaml.
+func (cfg *EffectiveConfig) PrintConfig(output string, w io.Writer) error {
+	var entries []ConfigEntry
+	for _, field := range cfg.fields() {
+		entries = append(entries, ConfigEntry{
+			Key:    field.key,
+			Value:  field.value(),
+			Source: cfg.Sources[field.key],
+			Env:    envName(field.key),
+		})
+	}
+
+	if output != "text" {
+		report := struct {
+			File    string        `json:"file" yaml:"file"`
+			Entries []ConfigEntry `json:"config" yaml:"config"`
+		}{cfg.File, entries}
+		if err := encodeOutput(w, output, report); err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(w, "Config file: %s\n\n", cfg.File)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, entry := range entries {
+		value := fmt.Sprint(entry.Value)
+		if list, ok := entry.Value.([]string); ok {
+			value = strings.Join(list, ",")
+		}
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.Key, value, entry.Source)
+	}
+	return tw.Flush()
+}
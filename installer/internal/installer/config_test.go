@@ -0,0 +1,131 @@
+package installer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveConfigPrecedence(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name  string
+		file  Config
+		env   map[string]string
+		flags Config
+		key   string
+		want  any
+		// wantSource is where the value comes from.
+		wantSource string
+		wantErr    bool
+	}{
+		{
+			name:       "default",
+			key:        "hooks_scope",
+			want:       HooksScopeGlobal,
+			wantSource: SourceDefault,
+		},
+		{
+			name:       "file overrides default",
+			file:       Config{HooksScope: HooksScopeRepo},
+			key:        "hooks_scope",
+			want:       HooksScopeRepo,
+			wantSource: SourceFile,
+		},
+		{
+			name:       "env overrides file",
+			file:       Config{Prefix: "/file"},
+			env:        map[string]string{"MAMBA_GITHOOK_PREFIX": "/env"},
+			key:        "prefix",
+			want:       "/env",
+			wantSource: SourceEnv,
+		},
+		{
+			name:       "flag overrides env and file",
+			file:       Config{Prefix: "/file"},
+			env:        map[string]string{"MAMBA_GITHOOK_PREFIX": "/env"},
+			flags:      Config{Prefix: "/flag"},
+			key:        "prefix",
+			want:       "/flag",
+			wantSource: SourceFlag,
+		},
+		{
+			name:       "flag overrides one key only",
+			file:       Config{Shells: []string{"zsh"}, LogLevel: "debug"},
+			flags:      Config{Shells: []string{"fish"}},
+			key:        "log_level",
+			want:       "debug",
+			wantSource: SourceFile,
+		},
+		{
+			name:       "env list is comma separated",
+			file:       Config{Shells: []string{"bash"}},
+			env:        map[string]string{"MAMBA_GITHOOK_SHELLS": "zsh, fish,"},
+			key:        "shells",
+			want:       []string{"zsh", "fish"},
+			wantSource: SourceEnv,
+		},
+		{
+			name:       "env false overrides file true",
+			file:       Config{InstallMicromamba: &yes},
+			env:        map[string]string{"MAMBA_GITHOOK_INSTALL_MICROMAMBA": "false"},
+			key:        "install_micromamba",
+			want:       false,
+			wantSource: SourceEnv,
+		},
+		{
+			name:       "flag false overrides env true",
+			env:        map[string]string{"MAMBA_GITHOOK_INSTALL_MICROMAMBA": "true"},
+			flags:      Config{InstallMicromamba: &no},
+			key:        "install_micromamba",
+			want:       false,
+			wantSource: SourceFlag,
+		},
+		{
+			name:       "empty env is unset",
+			file:       Config{LogLevel: "debug"},
+			env:        map[string]string{"MAMBA_GITHOOK_LOG_LEVEL": ""},
+			key:        "log_level",
+			want:       "debug",
+			wantSource: SourceFile,
+		},
+		{
+			name:    "invalid env boolean",
+			env:     map[string]string{"MAMBA_GITHOOK_INSTALL_MICROMAMBA": "maybe"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Ignore the configuration of the environment running the tests
+			for _, field := range (&Config{}).fields() {
+				t.Setenv(envName(field.key), "")
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			i := &Installer{SrcFS: testPayload, HomeDir: t.TempDir(), Shells: []string{"bash"}}
+
+			eff, err := i.ResolveConfig(&tt.file, "installer.yaml", &tt.flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			for _, field := range eff.fields() {
+				if field.key != tt.key {
+					continue
+				}
+				if got := field.value(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s = %#v, want %#v", tt.key, got, tt.want)
+				}
+			}
+			if got := eff.Sources[tt.key]; got != tt.wantSource {
+				t.Errorf("source of %s = %s, want %s", tt.key, got, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,473 @@
+package installer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+// healthCheck is an independent diagnosis run by Doctor. fix is nil for
+// problems the installer cannot repair.
+type healthCheck struct {
+	name string
+	run  func(ctx context.Context, i *Installer, m *Manifest) (ok bool, message string, err error)
+	fix  func(ctx context.Context, i *Installer, m *Manifest) error
+}
+
+var healthChecks []healthCheck
+
+// registerCheck adds a check to the registry run by Doctor. Checks run in
+// registration order.
+func registerCheck(check healthCheck) {
+	healthChecks = append(healthChecks, check)
+}
+
+// CheckResult is the outcome of a single health check.
+type CheckResult struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+	Fixable bool   `json:"fixable"`
+	Fixed   bool   `json:"fixed"`
+}
+
+func init() {
+	registerCheck(healthCheck{name: "installed", run: checkInstalled})
+	registerCheck(healthCheck{name: "git-version", run: checkGitVersion})
+	registerCheck(healthCheck{name: "hooks-path", run: checkHooksPath, fix: fixHooksPath})
+	registerCheck(healthCheck{name: "local-hooks-path", run: checkLocalHooksPath})
+	registerCheck(healthCheck{name: "hooks-executable", run: checkHooksExecutable, fix: fixHooksExecutable})
+	registerCheck(healthCheck{name: "hook-interpreters", run: checkHookInterpreters})
+	registerCheck(healthCheck{name: "environment", run: checkEnvironment, fix: fixEnvironment})
+	registerCheck(healthCheck{name: "rc-block-duplicated", run: checkRCBlocks, fix: fixEnvironment})
+	registerCheck(healthCheck{name: "micromamba", run: checkMicromamba, fix: fixMicromamba})
+	registerCheck(healthCheck{name: "man-page", run: checkManPage, fix: fixManPage})
+	registerCheck(healthCheck{name: "checksums", run: checkChecksums, fix: fixChecksums})
+}
+
+// Doctor runs every registered health check and prints the results. With
+// fix set, each failing check that has a repair is fixed and run again.
+// ErrDegraded is returned if any check still fails.
+func (i *Installer) Doctor(ctx context.Context, fix bool, w io.Writer) (results []CheckResult, err error) {
+	if fix {
+		var end func(*error)
+		ctx, end = i.startAudit(ctx, "doctor")
+		defer end(&err)
+	} else {
+		ctx = log.WithOperation(ctx, "doctor")
+	}
+
+	manifest, err := i.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		i.useManifestSettings(manifest)
+	}
+
+	degraded := false
+	for _, check := range healthChecks {
+		ctx := log.WithStep(ctx, check.name)
+		result := runCheck(ctx, i, manifest, check)
+		if !result.OK && fix && check.fix != nil {
+			log.Ctx(ctx).Info().Msgf("Fixing %s", check.name)
+			if err := check.fix(ctx, i, manifest); err != nil {
+				result.Message = fmt.Sprintf("%s (fix failed: %v)", result.Message, err)
+			} else if !i.DryRun {
+				result = runCheck(ctx, i, manifest, check)
+				result.Fixed = result.OK
+			}
+		}
+		if !result.OK {
+			degraded = true
+		}
+		results = append(results, result)
+
+		state := "ok"
+		switch {
+		case result.Fixed:
+			state = "fixed"
+		case !result.OK:
+			state = "FAIL"
+		}
+		fmt.Fprintf(w, "[%5s] %-20s %s\n", state, result.Name, result.Message)
+	}
+
+	if degraded {
+		return results, ErrDegraded
+	}
+	return results, nil
+}
+
+func runCheck(ctx context.Context, i *Installer, m *Manifest, check healthCheck) CheckResult {
+	result := CheckResult{Name: check.name, Fixable: check.fix != nil}
+	ok, message, err := check.run(ctx, i, m)
+	if err != nil {
+		message = err.Error()
+	}
+	result.OK = ok && err == nil
+	result.Message = message
+	return result
+}
+
+func checkInstalled(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if m == nil {
+		return false, "no install manifest found, run install", nil
+	}
+	return true, fmt.Sprintf("installed by installer version %s", m.InstallerVersion), nil
+}
+
+var gitVersionPattern = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// checkGitVersion verifies git supports core.hooksPath, added in git 2.9.
+func checkGitVersion(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	output, err := exec.CommandContext(ctx, "git", "--version").Output()
+	if err != nil {
+		return false, "git is not installed", nil
+	}
+
+	version := strings.TrimSpace(string(output))
+	match := gitVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return false, fmt.Sprintf("cannot parse %q", version), nil
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	if major < 2 || (major == 2 && minor < 9) {
+		return false, fmt.Sprintf("%s does not support core.hooksPath, git 2.9 or newer is required", version), nil
+	}
+	return true, version, nil
+}
+
+func checkHooksPath(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if i.HooksScope == HooksScopeRepo {
+		return true, "core.hooksPath is left to each repository", nil
+	}
+	status, err := i.checkGitHooks(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	if !status.GlobalCorrect {
+		return false, fmt.Sprintf("global core.hooksPath is %q, expected %s", status.Global, status.Expected), nil
+	}
+	return true, status.Global, nil
+}
+
+func fixHooksPath(ctx context.Context, i *Installer, m *Manifest) error {
+	return i.setupGitHooks(ctx)
+}
+
+// checkLocalHooksPath reports a core.hooksPath of the current repository
+// overriding the global one. It has no fix: repositories set it on purpose,
+// e.g. for husky, and only the user can decide to remove it.
+func checkLocalHooksPath(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if i.HooksScope == HooksScopeRepo {
+		return true, "core.hooksPath is left to each repository", nil
+	}
+	status, err := i.checkGitHooks(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	if status.Local != "" && !i.isOwnHooksPath(status.Local) {
+		return false, fmt.Sprintf("local core.hooksPath %s overrides the global hooks in this repository, "+
+			"remove it with git config --local --unset core.hooksPath if that is not intended", status.Local), nil
+	}
+	return true, "no local override", nil
+}
+
+// hookFiles returns the hook scripts git runs from the configured hooks
+// directory and the mamba-githook hooks they call.
+func (i *Installer) hookFiles() ([]string, error) {
+	var files []string
+	for _, dir := range []string{filepath.Join(i.TargetDir, "hooks"), i.chainDir()} {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+func checkHooksExecutable(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if i.OS == "windows" {
+		return true, "not applicable on Windows", nil
+	}
+
+	files, err := i.hookFiles()
+	if err != nil {
+		return false, "", err
+	}
+	var notExecutable []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, "", err
+		}
+		if info.Mode().Perm()&0111 == 0 {
+			notExecutable = append(notExecutable, file)
+		}
+	}
+	if len(notExecutable) > 0 {
+		return false, "not executable: " + strings.Join(notExecutable, ", "), nil
+	}
+	return true, fmt.Sprintf("%d hooks are executable", len(files)), nil
+}
+
+func fixHooksExecutable(ctx context.Context, i *Installer, m *Manifest) error {
+	files, err := i.hookFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0111 != 0 {
+			continue
+		}
+		if i.DryRun {
+			i.plan.add(PlanAction{Op: "chmod", Target: file, Mode: 0755})
+			continue
+		}
+		if err := os.Chmod(file, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHookInterpreters verifies that the shebang interpreter of every hook
+// exists.
+func checkHookInterpreters(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	files, err := i.hookFiles()
+	if err != nil {
+		return false, "", err
+	}
+
+	var missing []string
+	for _, file := range files {
+		interpreter, err := shebangInterpreter(file)
+		if err != nil {
+			return false, "", err
+		}
+		if interpreter == "" {
+			continue
+		}
+		if _, err := exec.LookPath(interpreter); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (%s)", interpreter, filepath.Base(file)))
+		}
+	}
+	if len(missing) > 0 {
+		return false, "missing interpreters: " + strings.Join(missing, ", "), nil
+	}
+	return true, "all hook interpreters found", nil
+}
+
+// shebangInterpreter returns the program named by the #! line of a script,
+// resolving "/usr/bin/env program" to program.
+func shebangInterpreter(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return "", nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if filepath.Base(fields[0]) == "env" && len(fields) > 1 {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return field, nil
+			}
+		}
+	}
+	return fields[0], nil
+}
+
+func checkEnvironment(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	statuses, err := i.checkEnvVars(ctx, i.shells())
+	if err != nil {
+		return false, "", err
+	}
+
+	var configured, broken []string
+	for _, status := range statuses {
+		configured = append(configured, status.Shell)
+		if !status.PathSet || !status.MambaGithookDirSet {
+			broken = append(broken, status.Shell)
+		}
+	}
+	if len(broken) > 0 {
+		return false, "PATH or MAMBA_GITHOOK_DIR not configured for " + strings.Join(broken, ", "), nil
+	}
+	return true, "configured for " + strings.Join(configured, ", "), nil
+}
+
+func fixEnvironment(ctx context.Context, i *Installer, m *Manifest) error {
+	return i.setupEnvironment(ctx)
+}
+
+func checkRCBlocks(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if i.OS == "windows" {
+		return true, "not applicable on Windows", nil
+	}
+
+	statuses, err := i.checkEnvVars(ctx, i.shells())
+	if err != nil {
+		return false, "", err
+	}
+	var duplicated []string
+	for _, status := range statuses {
+		if status.Blocks > 1 {
+			duplicated = append(duplicated, fmt.Sprintf("%s (%d blocks)", status.ConfigFile, status.Blocks))
+		}
+	}
+	if len(duplicated) > 0 {
+		return false, "duplicated blocks in " + strings.Join(duplicated, ", "), nil
+	}
+	return true, "no duplicated blocks", nil
+}
+
+func checkMicromamba(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	status := i.findMicromamba()
+	if !status.Present {
+		return false, "micromamba not found on PATH or at " + status.Path, nil
+	}
+	return true, status.Path, nil
+}
+
+func fixMicromamba(ctx context.Context, i *Installer, m *Manifest) error {
+	return i.installMicromamba(ctx)
+}
+
+func checkManPage(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if i.OS == "windows" {
+		return true, "not applicable on Windows", nil
+	}
+	path, _, _, err := i.destinationFor("mamba-githook.1")
+	if err != nil {
+		return false, "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false, "man page missing: " + path, nil
+	}
+	return true, path, nil
+}
+
+func fixManPage(ctx context.Context, i *Installer, m *Manifest) error {
+	dst, mode, ok, err := i.destinationFor("mamba-githook.1")
+	if err != nil || !ok {
+		return err
+	}
+	if err := i.mkdirAll(ctx, filepath.Dir(dst)); err != nil {
+		return err
+	}
+	_, err = i.installFile(ctx, "src/mamba-githook.1", dst, mode)
+	return err
+}
+
+func checkChecksums(ctx context.Context, i *Installer, m *Manifest) (bool, string, error) {
+	if m == nil {
+		return false, "no install manifest to verify against", nil
+	}
+
+	report := &StatusReport{}
+	i.checkManifestFiles(m, report)
+	if len(report.Problems) > 0 {
+		return false, strings.Join(report.Problems, "; "), nil
+	}
+	return true, fmt.Sprintf("%d files match the manifest", len(m.Files)), nil
+}
+
+// fixChecksums reinstalls missing files from the payload and
+// merges modified ones the way an upgrade does, keeping local changes, then
+// records the result in the manifest. The repair is a single transaction.
+func fixChecksums(ctx context.Context, i *Installer, m *Manifest) (err error) {
+	if m == nil {
+		return fmt.Errorf("no install manifest, run install instead")
+	}
+	if payloadVersion, err := i.PayloadVersion(); err == nil && payloadVersion != m.PayloadVersion {
+		log.Ctx(ctx).Warn().Msgf("Files are repaired from payload version %s, the installation is version %s, run upgrade to update the others",
+			payloadVersion, m.PayloadVersion)
+	}
+
+	if err := i.beginTransaction(ctx); err != nil {
+		return fmt.Errorf("failed to start repair transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := i.rollbackTransaction(ctx); rollbackErr != nil {
+				log.Ctx(ctx).Error().Err(rollbackErr).Msg("Failed to roll back repair")
+			}
+		}
+	}()
+
+	var files []ManifestFile
+	regenerate := false
+	for _, file := range m.Files {
+		if file.Source == "generated" {
+			regenerate = true
+			continue
+		}
+		ok, err := file.verify()
+		if err == nil && (ok || file.Customized) {
+			files = append(files, file)
+			continue
+		}
+		if err := i.mkdirAll(ctx, filepath.Dir(file.Path)); err != nil {
+			return err
+		}
+		merged, _, err := i.mergeFile(ctx, file.Source, file.Path, file.Mode, &file)
+		if err != nil {
+			return fmt.Errorf("failed to reinstall %s: %w", file.Path, err)
+		}
+		files = append(files, merged...)
+	}
+
+	if regenerate {
+		generated, err := i.writeChainEntrypoints(ctx, m.PreviousHooksPath)
+		if err != nil {
+			return fmt.Errorf("failed to regenerate hook entrypoints: %w", err)
+		}
+		files = append(files, generated...)
+	}
+
+	repaired := *m
+	repaired.Files = files
+	if err := i.writeManifest(ctx, &repaired); err != nil {
+		return fmt.Errorf("failed to write install manifest: %w", err)
+	}
+	if err := i.commitTransaction(); err != nil {
+		return err
+	}
+	*m = repaired
+	return nil
+}
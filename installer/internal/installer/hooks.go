@@ -0,0 +1,303 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+const (
+	// ProjectHooksDirEnv overrides the directory of the project hooks.
+	ProjectHooksDirEnv = "MAMBA_GITHOOK_PROJECT_GITHOOKS_DIR"
+	projectHooksDir    = ".githooks.d"
+
+	// unprioritized is the priority of hooks without one, they run last.
+	unprioritized = math.MaxInt
+)
+
+// HookScript is a project hook named <hook-type>.<priority>.<name>. The
+// priority is optional, lower priorities run first.
+type HookScript struct {
+	Path     string
+	Name     string
+	Priority int
+}
+
+// HookResult is the outcome of running a hook script.
+type HookResult struct {
+	Hook     HookScript
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// HookRun runs the project hooks of one hook type with the arguments and
+// standard input git passed to the hook.
+type HookRun struct {
+	Type string
+	// Dir is the directory of the project hooks.
+	Dir  string
+	Args []string
+	// Stdin is the input of the git hook, only read for the hook types git
+	// feeds data on stdin.
+	Stdin io.Reader
+	// Stdout receives the output of every hook under a header.
+	Stdout io.Writer
+	// DryRun only lists the hooks that would run.
+	DryRun bool
+	// Jobs is the number of hooks of the same priority run at the same
+	// time, at most one when unset.
+	Jobs int
+}
+
+// ProjectHooksDir returns the directory of the project hooks: the
+// MAMBA_GITHOOK_PROJECT_GITHOOKS_DIR environment variable or .githooks.d in
+// the root of the current repository.
+func ProjectHooksDir(ctx context.Context) (string, error) {
+	if dir := os.Getenv(ProjectHooksDirEnv); dir != "" {
+		return dir, nil
+	}
+	output, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the repository root: %w", commandError(ctx, err))
+	}
+	return filepath.Join(strings.TrimSpace(string(output)), projectHooksDir), nil
+}
+
+// DiscoverHooks returns the executable hook scripts of a hook type in dir,
+// ordered by priority and then by name.
+func DiscoverHooks(dir, hookType string) ([]HookScript, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks directory: %w", err)
+	}
+
+	var hooks []HookScript
+	for _, entry := range entries {
+		hook, ok := parseHookName(entry.Name(), hookType)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		hook.Path = filepath.Join(dir, entry.Name())
+
+		info, err := os.Stat(hook.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hook %s: %w", entry.Name(), err)
+		}
+		// Windows has no executable bit
+		if !info.Mode().IsRegular() || runtime.GOOS != "windows" && info.Mode()&0111 == 0 {
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+
+	slices.SortStableFunc(hooks, func(a, b HookScript) int {
+		if a.Priority != b.Priority {
+			if a.Priority < b.Priority {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return hooks, nil
+}
+
+// parseHookName parses a file name of the form <hook-type>[.<priority>][.<name>]
+// and reports whether it is a hook of hookType.
+func parseHookName(file, hookType string) (HookScript, bool) {
+	parts := strings.SplitN(file, ".", 3)
+	if parts[0] != hookType {
+		return HookScript{}, false
+	}
+
+	hook := HookScript{Name: file, Priority: unprioritized}
+	if len(parts) > 1 {
+		if priority, err := strconv.Atoi(parts[1]); err == nil && priority >= 0 {
+			hook.Priority = priority
+		}
+	}
+	return hook, true
+}
+
+// Run runs every project hook of the hook type in order of priority, the
+// hooks sharing a priority concurrently. A failing hook does not stop the
+// others, the returned error lists all failed hooks.
+func (r *HookRun) Run(ctx context.Context) ([]HookResult, error) {
+	ctx = log.WithOperation(ctx, "run")
+	if !slices.Contains(gitHookTypes, r.Type) {
+		return nil, fmt.Errorf("unknown git hook type %q", r.Type)
+	}
+
+	hooks, err := DiscoverHooks(r.Dir, r.Type)
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) == 0 {
+		log.Ctx(ctx).Debug().Msgf("No %s hooks in %s", r.Type, r.Dir)
+		return nil, nil
+	}
+	if r.DryRun {
+		for _, hook := range hooks {
+			fmt.Fprintf(r.Stdout, "would run %s %s\n", hook.Path, strings.Join(r.Args, " "))
+		}
+		return nil, nil
+	}
+
+	// Every hook gets its own copy of the data git passed on stdin. Other
+	// hook types get none, an open stdin, e.g. in CI, would block forever.
+	var stdin []byte
+	if r.Stdin != nil && slices.Contains(stdinHookTypes, r.Type) {
+		if stdin, err = io.ReadAll(r.Stdin); err != nil {
+			return nil, fmt.Errorf("failed to read hook input: %w", err)
+		}
+	}
+
+	results := make([]HookResult, 0, len(hooks))
+	for _, group := range priorityGroups(hooks) {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		results = append(results, r.runGroup(ctx, group, stdin)...)
+	}
+	return results, hookFailures(ctx, r.Type, results)
+}
+
+// priorityGroups splits hooks ordered by priority into runs of the same
+// priority. Hooks without a priority run one at a time.
+func priorityGroups(hooks []HookScript) [][]HookScript {
+	var groups [][]HookScript
+	for _, hook := range hooks {
+		if n := len(groups); n > 0 && hook.Priority != unprioritized && groups[n-1][0].Priority == hook.Priority {
+			groups[n-1] = append(groups[n-1], hook)
+		} else {
+			groups = append(groups, []HookScript{hook})
+		}
+	}
+	return groups
+}
+
+// runGroup runs hooks of the same priority, up to r.Jobs at a time. The
+// output of every hook is buffered and printed under a header once it
+// finishes, so that concurrent hooks do not interleave.
+func (r *HookRun) runGroup(ctx context.Context, hooks []HookScript, stdin []byte) []HookResult {
+	results := make([]HookResult, len(hooks))
+	var printMu sync.Mutex
+	jobs := min(max(r.Jobs, 1), len(hooks))
+	if jobs == 1 {
+		for j, hook := range hooks {
+			results[j] = r.runBuffered(ctx, hook, stdin, &printMu)
+		}
+		return results
+	}
+
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, jobs)
+	)
+	for j, hook := range hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[j] = r.runBuffered(ctx, hook, stdin, &printMu)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runBuffered runs a hook and prints its output under a header, holding
+// printMu while printing.
+func (r *HookRun) runBuffered(ctx context.Context, hook HookScript, stdin []byte, printMu *sync.Mutex) HookResult {
+	// The buffer is shared by stdout and stderr to keep their order
+	var output bytes.Buffer
+	w := &lockedWriter{w: &output}
+	result := r.runHook(log.WithStep(ctx, hook.Name), hook, stdin, w, w)
+
+	printMu.Lock()
+	defer printMu.Unlock()
+	fmt.Fprintf(r.Stdout, "==> %s (%s)\n", hook.Name, result.summary())
+	r.Stdout.Write(output.Bytes())
+	return result
+}
+
+func (r *HookRun) runHook(ctx context.Context, hook HookScript, stdin []byte, stdout, stderr io.Writer) HookResult {
+	log.Ctx(ctx).Info().Msgf("Running %s", hook.Name)
+	cmd := exec.CommandContext(ctx, hook.Path, r.Args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := commandError(ctx, cmd.Run())
+	result := HookResult{Hook: hook, Duration: time.Since(start), Err: err}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		log.Ctx(ctx).Warn().Int("exit_code", result.ExitCode).Msgf("%s failed", hook.Name)
+	case err != nil:
+		result.ExitCode = -1
+		log.Ctx(ctx).Warn().Err(err).Msgf("Failed to run %s", hook.Name)
+	default:
+		log.Ctx(ctx).Debug().Dur("duration", result.Duration).Msgf("%s passed", hook.Name)
+	}
+	return result
+}
+
+func (r HookResult) summary() string {
+	duration := r.Duration.Round(time.Millisecond)
+	switch {
+	case r.Err == nil:
+		return fmt.Sprintf("passed in %s", duration)
+	case r.ExitCode > 0:
+		return fmt.Sprintf("failed with exit code %d in %s", r.ExitCode, duration)
+	default:
+		return fmt.Sprintf("failed in %s: %v", duration, r.Err)
+	}
+}
+
+// lockedWriter serializes the writes of a hook's stdout and stderr, which
+// exec copies from separate goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// hookFailures returns an error naming the failed hooks, if any.
+func hookFailures(ctx context.Context, hookType string, results []HookResult) error {
+	var failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Hook.Name)
+		}
+	}
+	if len(failed) == 0 {
+		log.Ctx(ctx).Info().Msgf("All %d %s hooks passed", len(results), hookType)
+		return nil
+	}
+	return fmt.Errorf("%d of %d %s hooks failed: %s", len(failed), len(results), hookType, strings.Join(failed, ", "))
+}
@@ -0,0 +1,177 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseHookName(t *testing.T) {
+	tests := []struct {
+		file         string
+		wantOK       bool
+		wantPriority int
+	}{
+		{file: "pre-commit.40.lint", wantOK: true, wantPriority: 40},
+		{file: "pre-commit.40.lint.sh", wantOK: true, wantPriority: 40},
+		{file: "pre-commit.007.format", wantOK: true, wantPriority: 7},
+		{file: "pre-commit.0.first", wantOK: true, wantPriority: 0},
+		{file: "pre-commit.40", wantOK: true, wantPriority: 40},
+		{file: "pre-commit.lint", wantOK: true, wantPriority: unprioritized},
+		{file: "pre-commit", wantOK: true, wantPriority: unprioritized},
+		{file: "pre-commit.-1.lint", wantOK: true, wantPriority: unprioritized},
+		{file: "pre-push.10.jira", wantOK: false},
+		{file: "pre-commit-msg.10.check", wantOK: false},
+		{file: "pre-commit_environment.yml", wantOK: false},
+		{file: ".pre-commit.10.hidden", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			hook, ok := parseHookName(tt.file, "pre-commit")
+			if ok != tt.wantOK {
+				t.Fatalf("parseHookName() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if hook.Name != tt.file || hook.Priority != tt.wantPriority {
+				t.Errorf("parseHookName() = %+v, want priority %d", hook, tt.wantPriority)
+			}
+		})
+	}
+}
+
+func TestPriorityGroups(t *testing.T) {
+	hook := func(name string, priority int) HookScript {
+		return HookScript{Name: name, Priority: priority}
+	}
+
+	tests := []struct {
+		name  string
+		hooks []HookScript
+		want  [][]string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "one group per priority",
+			hooks: []HookScript{hook("a", 10), hook("b", 20), hook("c", 30)},
+			want:  [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:  "shared priorities",
+			hooks: []HookScript{hook("a", 10), hook("b", 10), hook("c", 20), hook("d", 40), hook("e", 40)},
+			want:  [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+		},
+		{
+			name:  "unprioritized hooks run alone",
+			hooks: []HookScript{hook("a", 10), hook("b", unprioritized), hook("c", unprioritized)},
+			want:  [][]string{{"a"}, {"b"}, {"c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			for _, group := range priorityGroups(tt.hooks) {
+				var names []string
+				for _, hook := range group {
+					names = append(names, hook.Name)
+				}
+				got = append(got, names)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("priorityGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookRunOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test hooks are shell scripts")
+	}
+
+	dir := t.TempDir()
+	for name, script := range map[string]string{
+		"pre-push.10.a": "echo out-a; echo err-a >&2",
+		"pre-push.10.b": "cat; echo \"args $*\"",
+		"pre-push.20.c": "exit 3",
+		"pre-push.last": "echo out-last",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, jobs := range []int{1, 2} {
+		t.Run(fmt.Sprintf("jobs=%d", jobs), func(t *testing.T) {
+			var out bytes.Buffer
+			run := &HookRun{
+				Type:   "pre-push",
+				Dir:    dir,
+				Args:   []string{"origin"},
+				Stdin:  strings.NewReader("refs\n"),
+				Stdout: &out,
+				Jobs:   jobs,
+			}
+			results, err := run.Run(context.Background())
+			if err == nil || !strings.Contains(err.Error(), "pre-push.20.c") {
+				t.Errorf("Run() error = %v, want pre-push.20.c to fail", err)
+			}
+			if len(results) != 4 {
+				t.Fatalf("Run() ran %d hooks, want 4", len(results))
+			}
+
+			// Every hook prints its output under its header
+			for _, section := range []string{
+				"==> pre-push.10.a (passed in ",
+				"out-a\nerr-a\n",
+				"==> pre-push.10.b (passed in ",
+				"refs\nargs origin\n",
+				"==> pre-push.20.c (failed with exit code 3 in ",
+				"==> pre-push.last (passed in ",
+			} {
+				if !strings.Contains(out.String(), section) {
+					t.Errorf("output is missing %q:\n%s", section, out.String())
+				}
+			}
+			if strings.Index(out.String(), "pre-push.20.c") > strings.Index(out.String(), "pre-push.last") {
+				t.Errorf("hooks ran out of priority order:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestHookRunIgnoresStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test hooks are shell scripts")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pre-commit.10.a"), []byte("#!/bin/sh\ncat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// git passes no input to pre-commit, an open stdin that is never
+	// written to must not block the hooks
+	stdin, w := io.Pipe()
+	defer w.Close()
+	var out bytes.Buffer
+	run := &HookRun{Type: "pre-commit", Dir: dir, Stdin: stdin, Stdout: &out, Jobs: 1}
+	if _, err := run.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "==> pre-commit.10.a (passed in ") {
+		t.Errorf("output = %q, want pre-commit.10.a to pass", out.String())
+	}
+}
@@ -1,46 +1,110 @@
 package installer
 
 import (
+	"context"
 	"embed"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"time"
 
 	"github.com/aydabd/mamba-githook/installer/internal/log"
 )
 
+// Version is the installer version. Release builds override it with
+// -ldflags "-X github.com/aydabd/mamba-githook/installer/internal/installer.Version=<version>".
+var Version = "dev"
+
+// defaultKeepBackups is the number of backup generations kept by default.
+const defaultKeepBackups = 5
+
 type Installer struct {
-	SrcFS      embed.FS
-	HomeDir    string
-	TargetDir  string
-	BinDir     string
-	BackupDir  string
-	OS         string
-	Shell      string
-	ProjectDir string
+	SrcFS     embed.FS
+	HomeDir   string
+	TargetDir string
+	BinDir    string
+	ManDir    string
+	BackupDir string
+	StateDir  string
+	// ConfigDir holds the persisted layout of the installation.
+	ConfigDir string
+	OS        string
+	// Shells lists the shells whose startup files are configured. When empty
+	// the shell detected from $SHELL is used.
+	Shells []string
+	// Chain selects the displaced hooks run after each mamba-githook hook.
+	// When nil an installation chains the repository hooks and the global
+	// hooks path it displaces, see defaultChain.
+	Chain map[string]ChainConfig
+	// Hooks lists the mamba-githook hooks to enable. When nil every hook of
+	// the payload is enabled.
+	Hooks []string
+	// MicromambaDir is the directory of the micromamba executable, exported
+	// as MICROMAMBA_BIN_FOLDER. When empty the hooks use their default.
+	MicromambaDir string
+	// TemplateDir replaces the embedded project templates when set.
+	TemplateDir string
+	// HooksScope selects where core.hooksPath is set: HooksScopeGlobal, the
+	// default when empty, or HooksScopeRepo.
+	HooksScope string
+	// InstallMicromamba installs micromamba after mamba-githook.
+	InstallMicromamba bool
+	ProjectDir        string
+	DryRun            bool
+	// Force makes Upgrade reinstall when the installed version is current
+	// and Restore use a backup that fails verification.
+	Force bool
+	// AllowDowngrade lets Upgrade replace a newer installation with the
+	// older embedded payload.
+	AllowDowngrade bool
+	// CompressBackups stores backup generations as tar.gz archives.
+	CompressBackups bool
+	// KeepBackups is the number of backup generations kept, zero keeps all.
+	KeepBackups int
+	// System installs for all users under /usr/local, configures the system
+	// core.hooksPath and an /etc/profile.d snippet instead of shell rc files.
+	System bool
+	// Root is the directory a system installation is made into, the way
+	// package builders stage files. Paths written into files and the git
+	// config are relative to the real root.
+	Root string
+
+	tx    *transaction
+	plan  *Plan
+	audit *auditTrail
+	// createdDirs are the directories created by this run.
+	createdDirs []string
 }
 
 func NewInstaller(srcFS embed.FS) *Installer {
 	// Determine the target and binary directories based on the OS
 	homeDir, _ := os.UserHomeDir()
-	var targetDir, binDir string
+	var targetDir, binDir, manDir, stateDir, configDir string
 	osType := runtime.GOOS
 
 	switch osType {
 	case "windows":
 		targetDir = filepath.Join(homeDir, "AppData", "Local", "mamba-githook")
 		binDir = filepath.Join(homeDir, "AppData", "Local", "bin")
+		manDir = filepath.Join(targetDir, "man", "man1")
+		stateDir = filepath.Join(homeDir, "AppData", "Local", "mamba-githook-state")
+		configDir = filepath.Join(homeDir, "AppData", "Roaming", "mamba-githook")
 	case "darwin", "linux":
-		targetDir = filepath.Join(homeDir, ".local", "share", "mamba-githook")
+		// Follow the XDG base directory specification
+		dataHome := xdgDir("XDG_DATA_HOME", filepath.Join(homeDir, ".local", "share"))
+		targetDir = filepath.Join(dataHome, "mamba-githook")
 		binDir = filepath.Join(homeDir, ".local", "bin")
+		manDir = filepath.Join(dataHome, "man", "man1")
+		stateDir = filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(homeDir, ".local", "state")), "mamba-githook")
+		configDir = filepath.Join(xdgDir("XDG_CONFIG_HOME", filepath.Join(homeDir, ".config")), "mamba-githook")
 	default:
 		log.Fatal().Msgf("Unsupported OS: %s", osType)
 	}
 
-	backupDir := filepath.Join(homeDir, ".mamba-githook-backup")
-	shell := detectShell()
-
+	backupDir := filepath.Join(stateDir, "backups")
 	// Calculate the project root directory (one level up from the installer directory)
 	execPath, err := os.Executable()
 	if err != nil {
@@ -51,228 +115,454 @@ func NewInstaller(srcFS embed.FS) *Installer {
 	projectDir := filepath.Dir(installerDir)
 
 	return &Installer{
-		SrcFS:      srcFS,
-		HomeDir:    homeDir,
-		TargetDir:  targetDir,
-		BinDir:     binDir,
-		BackupDir:  backupDir,
-		OS:         osType,
-		Shell:      shell,
-		ProjectDir: projectDir,
+		SrcFS:       srcFS,
+		HomeDir:     homeDir,
+		TargetDir:   targetDir,
+		BinDir:      binDir,
+		ManDir:      manDir,
+		BackupDir:   backupDir,
+		StateDir:    stateDir,
+		ConfigDir:   configDir,
+		OS:          osType,
+		ProjectDir:  projectDir,
+		KeepBackups: defaultKeepBackups,
 	}
 }
 
-func (i *Installer) Install() error {
-	log.Info().Msg("Starting mamba-githook installation")
+// Install installs mamba-githook as a single transaction: if any step fails,
+// every completed step is undone.
+func (i *Installer) Install(ctx context.Context) (err error) {
+	ctx, end := i.startAudit(ctx, "install")
+	defer end(&err)
+	log.Ctx(ctx).Info().Msg("Starting mamba-githook installation")
+
+	if err := i.beginTransaction(log.WithStep(ctx, "transaction")); err != nil {
+		return fmt.Errorf("failed to start install transaction: %w", err)
+	}
+
+	if err := i.install(ctx); err != nil {
+		if rollbackErr := i.rollbackTransaction(log.WithStep(ctx, "rollback")); rollbackErr != nil {
+			log.Ctx(ctx).Error().Err(rollbackErr).Msg("Failed to roll back installation")
+		}
+		return err
+	}
 
-	if err := i.createDirectories(); err != nil {
+	if err := i.commitTransaction(); err != nil {
+		return err
+	}
+
+	log.Ctx(ctx).Info().Msg("mamba-githook has been successfully installed")
+
+	if i.InstallMicromamba {
+		if status := i.findMicromamba(); status.Present {
+			log.Ctx(ctx).Info().Msgf("micromamba is already installed: %s", status.Path)
+			return nil
+		}
+		ctx := log.WithStep(ctx, "micromamba")
+		log.Ctx(ctx).Info().Msg("Installing micromamba")
+		if err := i.installMicromamba(ctx); err != nil {
+			return fmt.Errorf("mamba-githook was installed but installing micromamba failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (i *Installer) install(ctx context.Context) error {
+	installed, err := i.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	ctx = log.WithStep(ctx, "files")
+	if err := i.createDirectories(ctx); err != nil {
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	if err := i.copyProjectFiles(); err != nil {
+	files, err := i.copyProjectFiles(ctx, installed)
+	if err != nil {
 		return fmt.Errorf("failed to copy project files: %w", err)
 	}
 
-	if err := i.setupEnvironment(); err != nil {
+	ctx = log.WithStep(ctx, "environment")
+	if err := i.setupEnvironment(ctx); err != nil {
 		return fmt.Errorf("failed to set up environment: %w", err)
 	}
 
-	if err := i.setupGitHooks(); err != nil {
+	if installed != nil && i.OS != "windows" && !i.System {
+		var dropped []string
+		for _, shell := range installed.Shells {
+			if !slices.Contains(i.shells(), shell) {
+				dropped = append(dropped, shell)
+			}
+		}
+		if err := i.removeUnixEnvironment(ctx, dropped); err != nil {
+			return fmt.Errorf("failed to remove environment of previously configured shells: %w", err)
+		}
+	}
+
+	ctx = log.WithStep(ctx, "git-hooks")
+	previousHooksPath := ""
+	if i.HooksScope != HooksScopeRepo {
+		previousHooksPath, err = i.previousHooksPath(ctx, installed)
+		if err != nil {
+			return fmt.Errorf("failed to read Git hooks configuration: %w", err)
+		}
+	} else if installed != nil && installed.HooksScope != HooksScopeRepo {
+		// The previous installation set core.hooksPath globally
+		if err := i.restoreGitHooks(ctx, installed.PreviousHooksPath); err != nil {
+			return fmt.Errorf("failed to restore Git hooks configuration: %w", err)
+		}
+	}
+
+	if i.Chain == nil {
+		i.Chain = defaultChain(previousHooksPath)
+	}
+	chainFiles, err := i.writeChainEntrypoints(ctx, previousHooksPath)
+	if err != nil {
+		return fmt.Errorf("failed to generate hook entrypoints: %w", err)
+	}
+	files = append(files, chainFiles...)
+
+	if err := i.setupGitHooks(ctx); err != nil {
 		return fmt.Errorf("failed to set up Git hooks: %w", err)
 	}
 
-	log.Info().Msg("mamba-githook has been successfully installed")
+	ctx = log.WithStep(ctx, "manifest")
+	if installed != nil {
+		if err := i.removeStaleFiles(ctx, installed, files); err != nil {
+			return fmt.Errorf("failed to remove files of the previous installation: %w", err)
+		}
+	}
+
+	payloadVersion, err := i.PayloadVersion()
+	if err != nil {
+		return err
+	}
+
+	manifest := newManifest(files)
+	manifest.PayloadVersion = payloadVersion
+	manifest.PreviousHooksPath = previousHooksPath
+	manifest.Chain = i.chain()
+	manifest.Hooks = i.Hooks
+	manifest.MicromambaDir = i.MicromambaDir
+	manifest.TemplateDir = i.TemplateDir
+	manifest.HooksScope = i.HooksScope
+	if i.OS != "windows" && !i.System {
+		manifest.Shells = i.shells()
+	}
+	// The layout is written first so that the manifest records its directory
+	if err := i.writeLayout(ctx); err != nil {
+		return fmt.Errorf("failed to write install layout: %w", err)
+	}
+	manifest.Dirs = i.installedDirs(installed)
+	if err := i.writeManifest(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to write install manifest: %w", err)
+	}
 	return nil
 }
 
-func (i *Installer) setupEnvironment() error {
-	envVars := []string{
-		fmt.Sprintf("PATH=$PATH:%s", i.BinDir),
-		fmt.Sprintf("MAMBA_GITHOOK_DIR=%s", i.TargetDir),
-	}
+// installedDirs returns the directories created by this and the previous
+// installation outside of TargetDir and BackupDir, which remain.
+func (i *Installer) installedDirs(installed *Manifest) []string {
+	dirs := slices.Clone(i.createdDirs)
+	if installed != nil {
+		dirs = append(dirs, installed.Dirs...)
+	}
+	slices.Sort(dirs)
+	dirs = slices.Compact(dirs)
+	return slices.DeleteFunc(dirs, func(dir string) bool {
+		if isSubPath(i.TargetDir, dir) || isSubPath(i.BackupDir, dir) {
+			return true
+		}
+		_, err := os.Stat(dir)
+		return err != nil
+	})
+}
 
+func (i *Installer) setupEnvironment(ctx context.Context) error {
 	if i.OS == "windows" {
-		return i.setupWindowsEnvironment(envVars)
+		envVars := []string{
+			fmt.Sprintf("PATH=$PATH:%s", i.BinDir),
+			fmt.Sprintf("MAMBA_GITHOOK_DIR=%s", i.TargetDir),
+		}
+		if i.MicromambaDir != "" {
+			envVars = append(envVars, fmt.Sprintf("%s=%s", micromambaDirEnv, i.MicromambaDir))
+		}
+		return i.setupWindowsEnvironment(ctx, envVars)
+	}
+	if i.System {
+		return i.setupSystemEnvironment(ctx)
 	}
-	return i.setupUnixEnvironment(envVars)
+	return i.setupUnixEnvironment(ctx, i.shells())
 }
 
-func (i *Installer) removeEnvironment() error {
+func (i *Installer) removeEnvironment(ctx context.Context, shells []string) error {
 	if i.OS == "windows" {
-		return i.removeWindowsEnvironment()
+		return i.removeWindowsEnvironment(ctx)
+	}
+	if i.System {
+		return i.removeSystemEnvironment(ctx)
+	}
+	return i.removeUnixEnvironment(ctx, shells)
+}
+
+// checkEnvVars reports the environment configured for the given shells, the
+// profile snippet of a system installation or the Windows user environment.
+func (i *Installer) checkEnvVars(ctx context.Context, shells []string) ([]ShellStatus, error) {
+	switch {
+	case i.OS == "windows":
+		return []ShellStatus{i.checkWindowsEnvVars(ctx)}, nil
+	case i.System:
+		status, err := i.checkSystemEnvVars(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []ShellStatus{status}, nil
+	default:
+		return i.checkUnixEnvVars(ctx, shells)
 	}
-	return i.removeUnixEnvironment()
 }
 
-func (i *Installer) Uninstall() error {
-	log.Info().Msg("Starting mamba-githook uninstallation")
+func (i *Installer) Uninstall(ctx context.Context) (err error) {
+	ctx, end := i.startAudit(ctx, "uninstall")
+	defer end(&err)
+	log.Ctx(ctx).Info().Msg("Starting mamba-githook uninstallation")
 
-	if err := os.RemoveAll(i.TargetDir); err != nil {
-		return fmt.Errorf("failed to remove target directory: %w", err)
+	if err := i.recoverTransaction(log.WithStep(ctx, "transaction")); err != nil {
+		return err
 	}
-	if err := os.Remove(filepath.Join(i.BinDir, "mamba-githook")); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove mamba-githook binary: %w", err)
+
+	manifest, err := i.loadManifest()
+	if err != nil {
+		return err
 	}
 
-	if err := os.Remove(filepath.Join(i.HomeDir, ".local", "share", "man", "man1", "mamba-githook.1")); err != nil {
-		return fmt.Errorf("failed to remove mamba-githook man page: %w", err)
+	ctx = log.WithStep(ctx, "files")
+	shells := i.shells()
+	previousHooksPath := ""
+	if manifest == nil {
+		log.Ctx(ctx).Warn().Msg("No install manifest found, falling back to default installation paths")
+		if err := i.removeLegacyFiles(ctx); err != nil {
+			return err
+		}
+	} else {
+		shells = mergeShells(manifest.Shells, i.Shells)
+		previousHooksPath = manifest.PreviousHooksPath
+		if i.MicromambaDir == "" {
+			i.MicromambaDir = manifest.MicromambaDir
+		}
+		i.HooksScope = manifest.HooksScope
+		log.Ctx(ctx).Debug().Msgf("Removing files installed by installer version %s", manifest.InstallerVersion)
+		if err := i.removeManifestFiles(ctx, manifest); err != nil {
+			return err
+		}
 	}
 
-	if err := i.removeEnvironment(); err != nil {
+	ctx = log.WithStep(ctx, "environment")
+	if err := i.removeEnvironment(ctx, shells); err != nil {
 		return fmt.Errorf("failed to remove environment variables: %w", err)
 	}
 
-	if err := i.restoreGitHooks(); err != nil {
-		return fmt.Errorf("failed to restore Git hooks: %w", err)
+	ctx = log.WithStep(ctx, "git-hooks")
+	if i.HooksScope != HooksScopeRepo {
+		if err := i.restoreGitHooks(ctx, previousHooksPath); err != nil {
+			return fmt.Errorf("failed to restore Git hooks: %w", err)
+		}
+	}
+
+	if err := i.removeLayout(log.WithStep(ctx, "layout")); err != nil {
+		return fmt.Errorf("failed to remove install layout: %w", err)
+	}
+	if manifest != nil {
+		if err := i.removeCreatedDirs(ctx, manifest.Dirs); err != nil {
+			return fmt.Errorf("failed to remove directories: %w", err)
+		}
 	}
 
-	log.Info().Msg("mamba-githook has been successfully uninstalled")
+	log.Ctx(ctx).Info().Msg("mamba-githook has been successfully uninstalled")
 	return nil
 }
 
-func (i *Installer) Upgrade() error {
-	log.Info().Msg("Starting mamba-githook upgrade")
-
-	if err := i.Backup(); err != nil {
-		return fmt.Errorf("failed to create backup: %w", err)
+// removeManifestFiles removes every file recorded in the manifest, then the
+// manifest itself and any directories of TargetDir left empty.
+func (i *Installer) removeManifestFiles(ctx context.Context, m *Manifest) error {
+	for _, file := range m.Files {
+		if err := i.removeFile(ctx, file.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", file.Path, err)
+		}
 	}
 
-	if err := i.Uninstall(); err != nil {
-		return fmt.Errorf("failed to uninstall previous version: %w", err)
+	if err := i.removeFile(ctx, i.manifestPath()); err != nil {
+		return fmt.Errorf("failed to remove install manifest: %w", err)
 	}
 
-	if err := i.Install(); err != nil {
-		if restoreErr := i.Restore(); restoreErr != nil {
-			log.Error().Err(restoreErr).Msg("Failed to restore from backup after failed upgrade")
-		}
-		return fmt.Errorf("failed to install new version: %w", err)
+	if err := i.pruneEmptyDirs(ctx, i.TargetDir); err != nil {
+		return fmt.Errorf("failed to remove target directory: %w", err)
 	}
 
-	log.Info().Msg("mamba-githook has been successfully upgraded")
+	if _, err := os.Stat(i.TargetDir); err == nil && !i.DryRun {
+		log.Ctx(ctx).Warn().Msgf("%s contains files not installed by mamba-githook and was kept", i.TargetDir)
+	}
 	return nil
 }
 
-func (i *Installer) Backup() error {
-	log.Info().Msg("Creating backup")
-
-	if err := os.MkdirAll(i.BackupDir, 0755); err != nil {
-		return fmt.Errorf("failed to create backup directory: %w", err)
+// removeStaleFiles removes the files of a previous installation that the
+// current installation no longer provides.
+func (i *Installer) removeStaleFiles(ctx context.Context, installed *Manifest, files []ManifestFile) error {
+	current := make(map[string]bool, len(files))
+	for _, file := range files {
+		current[file.Path] = true
 	}
-
-	if err := i.copyDir(i.TargetDir, filepath.Join(i.BackupDir, "target")); err != nil {
-		return fmt.Errorf("failed to backup target directory: %w", err)
+	for _, file := range installed.Files {
+		if current[file.Path] {
+			continue
+		}
+		log.Ctx(ctx).Debug().Msgf("Removing stale file %s", file.Path)
+		if err := i.removeFile(ctx, file.Path); err != nil {
+			return err
+		}
 	}
+	return i.pruneEmptyDirs(ctx, i.TargetDir)
+}
 
-	if err := i.copyFile(filepath.Join(i.BinDir, "mamba-githook"), filepath.Join(i.BackupDir, "mamba-githook")); err != nil {
-		return fmt.Errorf("failed to backup mamba-githook binary: %w", err)
+// removeLegacyFiles removes an installation made before install manifests
+// were introduced.
+func (i *Installer) removeLegacyFiles(ctx context.Context) error {
+	if err := i.removeAll(ctx, i.TargetDir); err != nil {
+		return fmt.Errorf("failed to remove target directory: %w", err)
+	}
+	if err := i.removeFile(ctx, filepath.Join(i.BinDir, "mamba-githook")); err != nil {
+		return fmt.Errorf("failed to remove mamba-githook binary: %w", err)
 	}
 
-	log.Info().Msg("Backup created successfully")
+	if err := i.removeFile(ctx, filepath.Join(i.ManDir, "mamba-githook.1")); err != nil {
+		return fmt.Errorf("failed to remove mamba-githook man page: %w", err)
+	}
 	return nil
 }
 
-func (i *Installer) Restore() error {
-	log.Info().Msg("Starting restore from backup")
-
-	// Check if backup exists
-	if _, err := os.Stat(i.BackupDir); os.IsNotExist(err) {
-		return fmt.Errorf("backup directory does not exist: %w", err)
-	}
+// Upgrade replaces the installation with the embedded payload when it is
+// newer and prints the changelog between both versions to w.
+func (i *Installer) Upgrade(ctx context.Context, w io.Writer) (err error) {
+	ctx, end := i.startAudit(ctx, "upgrade")
+	defer end(&err)
+	log.Ctx(ctx).Info().Msg("Starting mamba-githook upgrade")
 
-	// Create a temporary directory for restoration
-	tempDir, err := os.MkdirTemp("", "mamba-githook-restore-")
+	manifest, err := i.loadManifest()
 	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
+		return err
 	}
-	defer os.RemoveAll(tempDir)
-
-	// Restore to temporary directory first
-	if err := i.copyDir(filepath.Join(i.BackupDir, "target"), filepath.Join(tempDir, "target")); err != nil {
-		return fmt.Errorf("failed to restore target directory: %w", err)
+	if manifest != nil {
+		log.Ctx(ctx).Info().Msgf("Upgrading installation from installer version %s (installed %s) to %s",
+			manifest.InstallerVersion, manifest.InstalledAt.Format(time.RFC3339), Version)
+		i.useManifestSettings(manifest)
 	}
 
-	if err := i.copyFile(filepath.Join(i.BackupDir, "mamba-githook"), filepath.Join(tempDir, "mamba-githook")); err != nil {
-		return fmt.Errorf("failed to restore mamba-githook binary: %w", err)
+	upgrade, err := i.checkUpgrade(log.WithStep(ctx, "check"), w)
+	if err != nil || !upgrade {
+		return err
 	}
 
-	// Restore man page if it exists and we're not on Windows
-	if i.OS != "windows" {
-		manPageSrc := filepath.Join(i.BackupDir, "man", "man1", "mamba-githook.1")
-		if _, err := os.Stat(manPageSrc); err == nil {
-			manPageDst := filepath.Join(tempDir, "man", "man1", "mamba-githook.1")
-			if err := i.copyFile(manPageSrc, manPageDst); err != nil {
-				return fmt.Errorf("failed to restore man page: %w", err)
-			}
+	// There is nothing to back up when no installation exists yet
+	if _, err := os.Stat(i.TargetDir); err == nil {
+		if err := i.Backup(log.WithStep(ctx, "backup")); err != nil {
+			return fmt.Errorf("failed to create backup: %w", err)
 		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check installation: %w", err)
 	}
 
-	// Set correct permissions
-	if err := i.setPermissions(tempDir); err != nil {
-		return fmt.Errorf("failed to set permissions: %w", err)
+	// Install upgrades in place, keeping local modifications, and rolls
+	// back on failure.
+	if err := i.Install(log.WithStep(ctx, "install")); err != nil {
+		return fmt.Errorf("failed to install new version: %w", err)
 	}
 
-	// Move temporary directory to final location
-	if err := os.RemoveAll(i.TargetDir); err != nil {
-		return fmt.Errorf("failed to remove existing target directory: %w", err)
+	log.Ctx(ctx).Info().Msg("mamba-githook has been successfully upgraded")
+	return nil
+}
+
+// checkUpgrade compares the installed version with the embedded payload. It
+// reports whether the upgrade should proceed: current installations are kept
+// unless Force is set and downgrades are refused unless AllowDowngrade is set.
+func (i *Installer) checkUpgrade(ctx context.Context, w io.Writer) (bool, error) {
+	installed, err := i.InstalledVersion(ctx)
+	if err != nil {
+		return false, err
 	}
-	if err := os.Rename(filepath.Join(tempDir, "target"), i.TargetDir); err != nil {
-		return fmt.Errorf("failed to move restored files to target directory: %w", err)
+	payload, err := i.PayloadVersion()
+	if err != nil {
+		return false, err
 	}
-	if err := os.Rename(filepath.Join(tempDir, "mamba-githook"), filepath.Join(i.BinDir, "mamba-githook")); err != nil {
-		return fmt.Errorf("failed to move restored binary: %w", err)
+	if installed == "" {
+		log.Ctx(ctx).Info().Msgf("No installed version found, installing %s", payload)
+		return true, nil
 	}
 
-	// Restore man page if not on Windows
-	if i.OS != "windows" {
-		manPageSrc := filepath.Join(tempDir, "man", "man1", "mamba-githook.1")
-		if _, err := os.Stat(manPageSrc); err == nil {
-			manPageDir, err := i.getManPageDir()
-			if err != nil {
-				return fmt.Errorf("failed to get man page directory: %w", err)
-			}
-			if err := os.Rename(manPageSrc, filepath.Join(manPageDir, "mamba-githook.1")); err != nil {
-				return fmt.Errorf("failed to move restored man page: %w", err)
-			}
+	cmp, err := compareVersions(payload, installed)
+	if err != nil {
+		return false, fmt.Errorf("failed to compare versions: %w", err)
+	}
+	switch {
+	case cmp == 0 && !i.Force:
+		log.Ctx(ctx).Info().Msgf("mamba-githook %s is already installed, use --force to reinstall", installed)
+		return false, nil
+	case cmp == 0:
+		log.Ctx(ctx).Info().Msgf("Reinstalling mamba-githook %s", installed)
+	case cmp < 0 && !i.AllowDowngrade:
+		return false, fmt.Errorf("installed version %s is newer than %s, use --allow-downgrade to downgrade", installed, payload)
+	case cmp < 0:
+		log.Ctx(ctx).Warn().Msgf("Downgrading mamba-githook from %s to %s", installed, payload)
+	default:
+		log.Ctx(ctx).Info().Msgf("Upgrading mamba-githook from %s to %s", installed, payload)
+		if err := i.printChangelog(w, installed, payload); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("Failed to read the changelog")
 		}
 	}
-
-	log.Info().Msg("Restore completed successfully")
-	return nil
+	return true, nil
 }
 
-func (i *Installer) Status() error {
-	log.Info().Msg("Checking mamba-githook installation status")
+// NonInteractiveInstall installs with the configured settings and defaults
+// only, without asking anything. Answers saved by the install wizard are
+// replayed by passing their config file.
+func (i *Installer) NonInteractiveInstall(ctx context.Context) error {
+	ctx = log.WithOperation(ctx, "install")
+	log.Ctx(ctx).Info().Msg("Starting non-interactive mamba-githook installation")
+	return i.Install(ctx)
+}
 
-	if _, err := os.Stat(i.TargetDir); os.IsNotExist(err) {
-		log.Info().Msg("mamba-githook is not installed")
-		return nil
+// useManifestSettings keeps the shells, chaining, hooks, hooks scope,
+// micromamba and template directories configured by a previous
+// installation unless they were set explicitly.
+func (i *Installer) useManifestSettings(m *Manifest) {
+	if len(i.Shells) == 0 {
+		i.Shells = m.Shells
 	}
-
-	binaryPath := filepath.Join(i.BinDir, "mamba-githook")
-	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		log.Info().Msg("mamba-githook binary is missing")
-		return nil
+	if i.Chain == nil {
+		i.Chain = m.Chain
 	}
-
-	if i.OS == "windows" {
-		if err := i.checkWindowsEnvVars(); err != nil {
-			return err
-		}
-	} else {
-		if err := i.checkUnixEnvVars(); err != nil {
-			return err
-		}
+	if i.Hooks == nil {
+		i.Hooks = m.Hooks
 	}
-
-	if err := i.checkGitHooks(); err != nil {
-		return err
+	if i.MicromambaDir == "" {
+		i.MicromambaDir = m.MicromambaDir
+	}
+	if i.TemplateDir == "" {
+		i.TemplateDir = m.TemplateDir
+	}
+	if i.HooksScope == "" {
+		i.HooksScope = m.HooksScope
 	}
-
-	log.Info().Msg("mamba-githook is properly installed and configured")
-	return nil
 }
 
-func (i *Installer) NonInteractiveInstall() error {
-	log.Info().Msg("Starting non-interactive mamba-githook installation")
-	return i.Install()
+// mergeShells returns the shells of a without duplicates, followed by the
+// shells of b not already in a.
+func mergeShells(a, b []string) []string {
+	var merged []string
+	for _, shell := range append(slices.Clone(a), b...) {
+		if !slices.Contains(merged, shell) {
+			merged = append(merged, shell)
+		}
+	}
+	return merged
 }
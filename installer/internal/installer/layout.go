@@ -0,0 +1,171 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+const layoutFileName = "layout.json"
+
+// Layout records the directories an installation was made into, so that
+// later runs find it without the flags passed to install. System
+// installations store the directories relative to the real root.
+type Layout struct {
+	TargetDir string `json:"target_dir"`
+	BinDir    string `json:"bin_dir"`
+	ManDir    string `json:"man_dir"`
+	BackupDir string `json:"backup_dir"`
+	StateDir  string `json:"state_dir"`
+}
+
+// LayoutOptions overrides the default directories. Prefix selects
+// <prefix>/bin, <prefix>/share/mamba-githook and <prefix>/share/man/man1,
+// the other options override single directories. For system installations
+// staged below a root directory they are taken relative to the root.
+type LayoutOptions struct {
+	Prefix    string
+	BinDir    string
+	DataDir   string
+	BackupDir string
+}
+
+// xdgDir returns the directory named by an XDG base directory variable, or
+// fallback when it is unset or not absolute as the specification requires.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return fallback
+}
+
+func (i *Installer) layoutPath() string {
+	return filepath.Join(i.ConfigDir, layoutFileName)
+}
+
+func (i *Installer) layout() Layout {
+	return Layout{
+		TargetDir: i.TargetDir,
+		BinDir:    i.BinDir,
+		ManDir:    i.ManDir,
+		BackupDir: i.BackupDir,
+		StateDir:  i.StateDir,
+	}
+}
+
+// mapLayout returns the layout with fn applied to every directory.
+func (l Layout) mapLayout(fn func(string) string) Layout {
+	return Layout{
+		TargetDir: fn(l.TargetDir),
+		BinDir:    fn(l.BinDir),
+		ManDir:    fn(l.ManDir),
+		BackupDir: fn(l.BackupDir),
+		StateDir:  fn(l.StateDir),
+	}
+}
+
+// loadLayout reads the persisted layout. It returns nil without error when
+// no layout was persisted.
+func (i *Installer) loadLayout() (*Layout, error) {
+	data, err := os.ReadFile(i.layoutPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layout: %w", err)
+	}
+
+	var layout Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("failed to parse layout %s: %w", i.layoutPath(), err)
+	}
+	layout = layout.mapLayout(i.rooted)
+	return &layout, nil
+}
+
+// writeLayout persists the directories of the installation.
+func (i *Installer) writeLayout(ctx context.Context) error {
+	data, err := json.MarshalIndent(i.layout().mapLayout(i.unrooted), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := i.mkdirAll(ctx, i.ConfigDir); err != nil {
+		return err
+	}
+	_, err = i.writeFile(ctx, i.layoutPath(), strings.NewReader(string(data)+"\n"), 0644, "generated")
+	return err
+}
+
+// removeLayout removes the persisted layout and the config directory if it
+// is empty.
+func (i *Installer) removeLayout(ctx context.Context) error {
+	if err := i.removeFile(ctx, i.layoutPath()); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(i.ConfigDir); err == nil && len(entries) == 0 {
+		return i.removeDir(ctx, i.ConfigDir)
+	}
+	return nil
+}
+
+// ApplyLayout selects the directories to use: the persisted layout of an
+// existing installation, overridden by the given options.
+func (i *Installer) ApplyLayout(ctx context.Context, opts LayoutOptions) error {
+	saved, err := i.loadLayout()
+	if err != nil {
+		return err
+	}
+	defaultBackupDir := i.BackupDir
+	if saved != nil {
+		log.Ctx(ctx).Debug().Msgf("Using the layout persisted in %s", i.layoutPath())
+		i.TargetDir = saved.TargetDir
+		i.BinDir = saved.BinDir
+		i.ManDir = saved.ManDir
+		i.BackupDir = saved.BackupDir
+		i.StateDir = saved.StateDir
+	}
+	if opts.BackupDir == "" {
+		if err := i.migrateBackupDir(ctx, saved, defaultBackupDir); err != nil {
+			return err
+		}
+	}
+	previous := i.layout()
+
+	if opts.Prefix != "" {
+		prefix, err := filepath.Abs(opts.Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %q: %w", opts.Prefix, err)
+		}
+		prefix = i.rooted(prefix)
+		i.BinDir = filepath.Join(prefix, "bin")
+		i.TargetDir = filepath.Join(prefix, "share", "mamba-githook")
+		i.ManDir = filepath.Join(prefix, "share", "man", "man1")
+	}
+	for _, dir := range []struct {
+		value string
+		dst   *string
+	}{
+		{opts.BinDir, &i.BinDir},
+		{opts.DataDir, &i.TargetDir},
+		{opts.BackupDir, &i.BackupDir},
+	} {
+		if dir.value == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir.value)
+		if err != nil {
+			return fmt.Errorf("invalid directory %q: %w", dir.value, err)
+		}
+		*dir.dst = i.rooted(abs)
+	}
+
+	if saved != nil && i.layout() != previous {
+		log.Ctx(ctx).Warn().Msgf("The directories differ from the installation recorded in %s", i.layoutPath())
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestSchemaVersion is bumped whenever the manifest layout changes in a
+// way older installers cannot read.
+const manifestSchemaVersion = 1
+
+const manifestFileName = "install-manifest.json"
+
+// Manifest is the receipt written into TargetDir by every installation. It
+// records each file the installer laid down so that Uninstall, Status and
+// Upgrade never have to guess.
+type Manifest struct {
+	SchemaVersion    int       `json:"schema_version"`
+	InstallerVersion string    `json:"installer_version"`
+	InstalledAt      time.Time `json:"installed_at"`
+	// PayloadVersion is the mamba-githook version of the installed files.
+	PayloadVersion string   `json:"payload_version,omitempty"`
+	Shells         []string `json:"shells,omitempty"`
+	// PreviousHooksPath is the global core.hooksPath that was set before
+	// the installation, restored on uninstall.
+	PreviousHooksPath string `json:"previous_hooks_path,omitempty"`
+	// Chain holds the hook chaining configuration per hook type.
+	Chain map[string]ChainConfig `json:"chain"`
+	// Hooks lists the enabled mamba-githook hooks, all when empty.
+	Hooks         []string `json:"hooks,omitempty"`
+	MicromambaDir string   `json:"micromamba_dir,omitempty"`
+	TemplateDir   string   `json:"template_dir,omitempty"`
+	// HooksScope is repo when core.hooksPath is left to each repository.
+	HooksScope string `json:"hooks_scope,omitempty"`
+
+	Files []ManifestFile `json:"files"`
+	// Dirs lists the directories the installation created outside of
+	// TargetDir, removed on uninstall when they are empty.
+	Dirs []string `json:"dirs,omitempty"`
+}
+
+// ManifestFile describes a single installed file.
+type ManifestFile struct {
+	Path   string      `json:"path"`
+	SHA256 string      `json:"sha256"`
+	Mode   fs.FileMode `json:"mode"`
+	Source string      `json:"source"`
+	// Customized marks a locally modified file kept by an upgrade. SHA256
+	// is the checksum of the pristine payload file.
+	Customized bool `json:"customized,omitempty"`
+}
+
+func (i *Installer) manifestPath() string {
+	return filepath.Join(i.TargetDir, manifestFileName)
+}
+
+func newManifest(files []ManifestFile) *Manifest {
+	return &Manifest{
+		SchemaVersion:    manifestSchemaVersion,
+		InstallerVersion: Version,
+		InstalledAt:      time.Now().UTC(),
+		Files:            files,
+	}
+}
+
+// loadManifest reads the manifest of the current installation. It returns
+// nil without error when no manifest exists.
+func (i *Installer) loadManifest() (*Manifest, error) {
+	data, err := os.ReadFile(i.manifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", i.manifestPath(), err)
+	}
+	if m.SchemaVersion > manifestSchemaVersion {
+		return nil, fmt.Errorf("manifest schema version %d is newer than supported version %d", m.SchemaVersion, manifestSchemaVersion)
+	}
+	for n := range m.Files {
+		m.Files[n].Path = i.rooted(m.Files[n].Path)
+	}
+	return &m, nil
+}
+
+// writeManifest writes the manifest into TargetDir. The paths are stored
+// relative to the real root so that a staged installation can be packaged.
+func (i *Installer) writeManifest(ctx context.Context, m *Manifest) error {
+	stored := *m
+	stored.Files = make([]ManifestFile, len(m.Files))
+	for n, file := range m.Files {
+		file.Path = i.unrooted(file.Path)
+		stored.Files[n] = file
+	}
+	data, err := json.MarshalIndent(&stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if i.DryRun {
+		i.planFileWrite(i.manifestPath(), int64(len(data)+1), 0644)
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(i.TargetDir, "manifest-*")
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if err := i.journalFileWrite(ctx, i.manifestPath()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, i.manifestPath()); err != nil {
+		return err
+	}
+	i.auditFile(i.manifestPath())
+	return nil
+}
+
+// verify reports whether the file on disk still matches the manifest entry.
+func (f ManifestFile) verify() (bool, error) {
+	sum, err := fileSHA256(f.Path)
+	if err != nil {
+		return false, err
+	}
+	return sum == f.SHA256, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,94 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+// newFileSuffix is appended to the new version of a file that was modified
+// locally and changed in the payload.
+const newFileSuffix = ".new"
+
+// mergeFile installs the payload file src at dst while keeping local
+// modifications, the way dpkg handles conffiles. The pristine checksum of
+// the previous installation decides:
+//
+//   - the file is missing or unmodified: the new version is installed
+//   - the file is modified and the payload did not change: the file is kept
+//   - both changed: the file is kept and the new version is written to
+//     dst.new, which is reported as a conflict
+//
+// old is the manifest entry of the previous installation, nil if the file
+// was not installed before.
+func (i *Installer) mergeFile(ctx context.Context, src, dst string, mode os.FileMode, old *ManifestFile) (files []ManifestFile, conflict bool, err error) {
+	if old == nil || !i.isTargetFile(dst) {
+		file, err := i.installFile(ctx, src, dst, mode)
+		return []ManifestFile{file}, false, err
+	}
+
+	current, err := fileSHA256(dst)
+	if os.IsNotExist(err) {
+		file, err := i.installFile(ctx, src, dst, mode)
+		return []ManifestFile{file}, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	pristine, err := i.sourceSHA256(src)
+	if err != nil {
+		return nil, false, err
+	}
+	if current == old.SHA256 || current == pristine {
+		file, err := i.installFile(ctx, src, dst, mode)
+		return []ManifestFile{file}, false, err
+	}
+
+	// The file was modified locally, keep it and record the new pristine
+	// checksum so that the next upgrade compares against this version.
+	kept := ManifestFile{Path: dst, SHA256: pristine, Mode: mode, Source: src, Customized: true}
+	if pristine == old.SHA256 {
+		log.Ctx(ctx).Info().Msgf("Keeping locally modified %s", dst)
+		if i.DryRun {
+			i.plan.add(PlanAction{Op: "keep", Target: dst, Detail: "modified locally"})
+		}
+		return []ManifestFile{kept}, false, nil
+	}
+
+	log.Ctx(ctx).Warn().Msgf("Keeping locally modified %s, the new version is written to %s", dst, dst+newFileSuffix)
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "keep", Target: dst, Detail: "modified locally, conflicts with the new version"})
+	}
+	file, err := i.installFile(ctx, src, dst+newFileSuffix, mode)
+	if err != nil {
+		return nil, false, err
+	}
+	return []ManifestFile{kept, file}, true, nil
+}
+
+// isTargetFile reports whether path is part of the hooks, templates and
+// scripts in TargetDir that teams customize. The binary and the man page are
+// always replaced.
+func (i *Installer) isTargetFile(path string) bool {
+	return isSubPath(i.TargetDir, path)
+}
+
+// sourceSHA256 returns the checksum of a payload file.
+func (i *Installer) sourceSHA256(src string) (string, error) {
+	file, err := i.openSource(src)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
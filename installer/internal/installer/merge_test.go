@@ -0,0 +1,171 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//go:embed testdata/payload
+var testPayload embed.FS
+
+const testPayloadHook = "testdata/payload/hook"
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMergeFile(t *testing.T) {
+	const payload = "new\n"
+
+	tests := []struct {
+		name string
+		// current is the installed content, nothing is installed when empty.
+		current string
+		// installed is the pristine content recorded by the previous
+		// installation, the file was not installed before when empty.
+		installed    string
+		wantContent  string
+		wantNew      bool
+		wantConflict bool
+		wantKept     bool
+	}{
+		{
+			name:        "not installed before",
+			wantContent: payload,
+		},
+		{
+			name:        "missing",
+			installed:   "old\n",
+			wantContent: payload,
+		},
+		{
+			name:        "unmodified",
+			current:     "old\n",
+			installed:   "old\n",
+			wantContent: payload,
+		},
+		{
+			name:        "modified to the new version",
+			current:     payload,
+			installed:   "old\n",
+			wantContent: payload,
+		},
+		{
+			name:        "modified, payload unchanged",
+			current:     "mine\n",
+			installed:   payload,
+			wantContent: "mine\n",
+			wantKept:    true,
+		},
+		{
+			name:         "modified, payload changed",
+			current:      "mine\n",
+			installed:    "old\n",
+			wantContent:  "mine\n",
+			wantNew:      true,
+			wantConflict: true,
+			wantKept:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			i := &Installer{SrcFS: testPayload, TargetDir: dir}
+			dst := filepath.Join(dir, "hook")
+			if tt.current != "" {
+				if err := os.WriteFile(dst, []byte(tt.current), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var old *ManifestFile
+			if tt.installed != "" {
+				old = &ManifestFile{Path: dst, SHA256: sha256Hex(tt.installed), Mode: 0755, Source: testPayloadHook}
+			}
+
+			files, conflict, err := i.mergeFile(context.Background(), testPayloadHook, dst, 0755, old)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if conflict != tt.wantConflict {
+				t.Errorf("conflict = %v, want %v", conflict, tt.wantConflict)
+			}
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.wantContent {
+				t.Errorf("content = %q, want %q", data, tt.wantContent)
+			}
+			if _, err := os.Stat(dst + newFileSuffix); (err == nil) != tt.wantNew {
+				t.Errorf("%s written = %v, want %v", newFileSuffix, err == nil, tt.wantNew)
+			}
+
+			if len(files) == 0 || files[0].Path != dst {
+				t.Fatalf("manifest entries = %+v, want %s first", files, dst)
+			}
+			if files[0].Customized != tt.wantKept {
+				t.Errorf("Customized = %v, want %v", files[0].Customized, tt.wantKept)
+			}
+			// The manifest always records the pristine checksum of the payload
+			if files[0].SHA256 != sha256Hex(payload) {
+				t.Errorf("SHA256 = %s, want the checksum of the payload", files[0].SHA256)
+			}
+		})
+	}
+}
+
+func TestCopyTemplates(t *testing.T) {
+	ctx := context.Background()
+	templates := t.TempDir()
+	target := t.TempDir()
+	writeTestFile(t, filepath.Join(templates, "a", "pre-commit.yaml"), "v1\n")
+	writeTestFile(t, filepath.Join(templates, "b.yaml"), "v1\n")
+	i := &Installer{SrcFS: testPayload, TargetDir: target, TemplateDir: templates}
+
+	files, _, err := i.copyTemplates(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := make(map[string]*ManifestFile)
+	for n, file := range files {
+		if file.Source != templateSource+"a/pre-commit.yaml" && file.Source != templateSource+"b.yaml" {
+			t.Errorf("Source = %q, want a template source", file.Source)
+		}
+		previous[file.Path] = &files[n]
+	}
+
+	// A locally modified template is kept when the template directory changes
+	modified := filepath.Join(target, "templates", "a", "pre-commit.yaml")
+	writeTestFile(t, modified, "mine\n")
+	writeTestFile(t, filepath.Join(templates, "a", "pre-commit.yaml"), "v2\n")
+	_, conflicts, err := i.copyTemplates(ctx, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != modified {
+		t.Errorf("conflicts = %q, want %s", conflicts, modified)
+	}
+	if data, _ := os.ReadFile(modified); string(data) != "mine\n" {
+		t.Errorf("content = %q, want the local modification", data)
+	}
+
+	// A missing template is repaired from the template directory
+	removed := previous[filepath.Join(target, "templates", "b.yaml")]
+	if err := os.Remove(removed.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := i.mergeFile(ctx, removed.Source, removed.Path, removed.Mode, removed); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(removed.Path); string(data) != "v1\n" {
+		t.Errorf("repaired content = %q, want the template", data)
+	}
+}
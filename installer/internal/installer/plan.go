@@ -0,0 +1,155 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// PlanAction is a single change an operation would make to the system.
+type PlanAction struct {
+	Op     string
+	Target string
+	Detail string
+	Size   int64
+	Mode   fs.FileMode
+}
+
+// Plan collects the changes an operation would make when the installer runs
+// in dry-run mode.
+type Plan struct {
+	Actions []PlanAction
+
+	// deleted tracks paths planned for removal so that directory pruning can
+	// tell which directories would end up empty, created the directories
+	// already planned for creation.
+	deleted map[string]bool
+	created map[string]bool
+}
+
+func newPlan() *Plan {
+	return &Plan{deleted: make(map[string]bool), created: make(map[string]bool)}
+}
+
+func (p *Plan) add(action PlanAction) {
+	p.Actions = append(p.Actions, action)
+}
+
+// Print writes the plan in a human readable table.
+func (p *Plan) Print(w io.Writer) {
+	if len(p.Actions) == 0 {
+		fmt.Fprintln(w, "Dry run: no changes")
+		return
+	}
+
+	fmt.Fprintf(w, "Dry run: %d planned changes\n", len(p.Actions))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, a := range p.Actions {
+		var details []string
+		if a.Size > 0 {
+			details = append(details, fmt.Sprintf("%d bytes", a.Size))
+		}
+		if a.Mode != 0 {
+			details = append(details, fmt.Sprintf("%04o", a.Mode.Perm()))
+		}
+		if a.Detail != "" {
+			details = append(details, a.Detail)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", a.Op, a.Target, strings.Join(details, ", "))
+	}
+	tw.Flush()
+}
+
+// Plan returns the changes collected in dry-run mode, or nil when the
+// installer is not running in dry-run mode.
+func (i *Installer) Plan() *Plan {
+	return i.plan
+}
+
+// SetDryRun makes every following operation record its changes in a plan
+// instead of applying them.
+func (i *Installer) SetDryRun(dryRun bool) {
+	i.DryRun = dryRun
+	if dryRun {
+		i.plan = newPlan()
+	} else {
+		i.plan = nil
+	}
+}
+
+// planFileWrite records that path would be written with size bytes and mode.
+func (i *Installer) planFileWrite(path string, size int64, mode fs.FileMode) {
+	op := "create"
+	if _, err := os.Stat(path); err == nil {
+		op = "replace"
+	}
+	i.plan.add(PlanAction{Op: op, Target: path, Size: size, Mode: mode})
+}
+
+// removeFile removes a single file, ignoring files that do not exist. Inside
+// a transaction the file is backed up so the removal can be undone.
+func (i *Installer) removeFile(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if i.DryRun {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		i.plan.add(PlanAction{Op: "delete", Target: path, Size: info.Size(), Mode: info.Mode()})
+		i.plan.deleted[path] = true
+		return nil
+	}
+
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if err := i.journalFileWrite(ctx, path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	i.auditFile(path)
+	return nil
+}
+
+// removeDir removes an empty directory.
+func (i *Installer) removeDir(ctx context.Context, path string) error {
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "rmdir", Target: path})
+		i.plan.deleted[path] = true
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// removeAll removes path and everything below it.
+func (i *Installer) removeAll(ctx context.Context, path string) error {
+	if !i.DryRun {
+		return os.RemoveAll(path)
+	}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return i.removeFile(ctx, p)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return i.pruneEmptyDirs(ctx, path)
+}
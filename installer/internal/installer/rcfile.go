@@ -0,0 +1,261 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+const (
+	rcBlockBegin   = "# >>> mamba-githook >>>"
+	rcBlockEnd     = "# <<< mamba-githook <<<"
+	rcBlockComment = "# Managed by mamba-githook-installer, edits inside this block are overwritten."
+)
+
+// writeManagedBlock creates, updates or, when lines is empty, removes the
+// mamba-githook block of a shell rc file. Lines appended by installers that
+// predate the managed block are dropped as well. The rc file is backed up
+// before it is changed.
+func (i *Installer) writeManagedBlock(ctx context.Context, filename string, lines []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if os.IsNotExist(err) && len(lines) == 0 {
+		return nil
+	}
+
+	oldContent := string(data)
+	newContent, err := replaceManagedBlock(removeLegacyLines(oldContent, i.legacyRCLines()), lines)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", filename, err)
+	}
+	if newContent == oldContent {
+		log.Ctx(ctx).Debug().Msgf("%s is up to date", filename)
+		return nil
+	}
+
+	diff := lineDiff(splitLines(oldContent), splitLines(newContent))
+	if i.DryRun {
+		for _, line := range diff {
+			op := "rc-add"
+			if strings.HasPrefix(line, "-") {
+				op = "rc-remove"
+			}
+			i.plan.add(PlanAction{Op: op, Target: filename, Detail: line[1:]})
+		}
+		return nil
+	}
+
+	if data != nil {
+		if err := i.backupRCFile(ctx, filename); err != nil {
+			return err
+		}
+	}
+
+	// An rc file holding nothing but the managed block is left empty rather
+	// than removed, the user may have created it
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+		// Update the target of a symlinked rc file, e.g. from a dotfiles
+		// repository, instead of replacing the link
+		target, err := filepath.EvalSymlinks(filename)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", filename, err)
+		}
+		filename = target
+	}
+	if err := i.mkdirAll(ctx, filepath.Dir(filename)); err != nil {
+		return err
+	}
+	if _, err := i.writeFile(ctx, filename, strings.NewReader(newContent), mode, "generated"); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+
+	log.Ctx(ctx).Debug().Msgf("Updated %s:\n%s", filename, strings.Join(diff, "\n"))
+	return nil
+}
+
+// readManagedBlock returns the lines inside the mamba-githook block of an rc
+// file and how many blocks the file contains.
+func readManagedBlock(filename string) (lines []string, blocks int, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	inBlock := false
+	for _, line := range splitLines(string(data)) {
+		switch strings.TrimSpace(line) {
+		case rcBlockBegin:
+			inBlock = true
+			blocks++
+			continue
+		case rcBlockEnd:
+			inBlock = false
+			continue
+		}
+		if inBlock && blocks == 1 && line != rcBlockComment {
+			lines = append(lines, line)
+		}
+	}
+	return lines, blocks, nil
+}
+
+// backupRCFile keeps a timestamped copy of an rc file in the state directory.
+func (i *Installer) backupRCFile(ctx context.Context, filename string) error {
+	dir := filepath.Join(i.StateDir, "rc-backups")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create rc backup directory: %w", err)
+	}
+
+	backup := filepath.Join(dir, fmt.Sprintf("%s.%s", strings.TrimPrefix(filepath.Base(filename), "."), time.Now().UTC().Format("20060102T150405.000000000Z")))
+	if err := copyFileMode(filename, backup); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", filename, err)
+	}
+	log.Ctx(ctx).Debug().Msgf("Backed up %s to %s", filename, backup)
+	return nil
+}
+
+// legacyRCLines returns the lines older installers appended to rc files.
+func (i *Installer) legacyRCLines() []string {
+	return []string{
+		fmt.Sprintf("export PATH=$PATH:%s", i.BinDir),
+		fmt.Sprintf("export MAMBA_GITHOOK_DIR=%s", i.TargetDir),
+	}
+}
+
+// replaceManagedBlock removes every mamba-githook block from content and, if
+// lines is not empty, writes a single block in place of the first one or
+// appends it to the end. A block without an end marker is an error, as
+// everything after its begin marker would be removed.
+func replaceManagedBlock(content string, lines []string) (string, error) {
+	var block []string
+	if len(lines) > 0 {
+		block = append(block, rcBlockBegin, rcBlockComment)
+		block = append(block, lines...)
+		block = append(block, rcBlockEnd)
+	}
+
+	var out []string
+	inBlock, placed := false, false
+	beginLine := 0
+	for n, line := range splitLines(content) {
+		switch {
+		case strings.TrimSpace(line) == rcBlockBegin:
+			inBlock = true
+			beginLine = n + 1
+			if !placed && len(block) > 0 {
+				out = append(out, block...)
+				placed = true
+			} else if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+				// Drop the blank separator line that was added with the block
+				out = out[:len(out)-1]
+			}
+		case inBlock:
+			if strings.TrimSpace(line) == rcBlockEnd {
+				inBlock = false
+			}
+		default:
+			out = append(out, line)
+		}
+	}
+	if inBlock {
+		return "", fmt.Errorf("mamba-githook block starting at line %d has no end marker %q", beginLine, rcBlockEnd)
+	}
+	if !placed && len(block) > 0 {
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+			out = append(out, "")
+		}
+		out = append(out, block...)
+	}
+
+	if len(out) == 0 {
+		return "", nil
+	}
+	return strings.Join(out, "\n") + "\n", nil
+}
+
+// removeLegacyLines drops lines of content that exactly match one of legacy.
+func removeLegacyLines(content string, legacy []string) string {
+	var out []string
+	removed := false
+	for _, line := range splitLines(content) {
+		drop := false
+		for _, l := range legacy {
+			if strings.TrimSpace(line) == l {
+				drop = true
+				break
+			}
+		}
+		if drop {
+			removed = true
+			continue
+		}
+		out = append(out, line)
+	}
+	if !removed {
+		return content
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// lineDiff returns the lines removed from a ("-" prefix) and added in b
+// ("+" prefix), based on their longest common subsequence.
+func lineDiff(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for j := range lcs {
+		lcs[j] = make([]int, len(b)+1)
+	}
+	for x := len(a) - 1; x >= 0; x-- {
+		for y := len(b) - 1; y >= 0; y-- {
+			if a[x] == b[y] {
+				lcs[x][y] = lcs[x+1][y+1] + 1
+			} else {
+				lcs[x][y] = max(lcs[x+1][y], lcs[x][y+1])
+			}
+		}
+	}
+
+	var diff []string
+	x, y := 0, 0
+	for x < len(a) && y < len(b) {
+		switch {
+		case a[x] == b[y]:
+			x++
+			y++
+		case lcs[x+1][y] >= lcs[x][y+1]:
+			diff = append(diff, "-"+a[x])
+			x++
+		default:
+			diff = append(diff, "+"+b[y])
+			y++
+		}
+	}
+	for ; x < len(a); x++ {
+		diff = append(diff, "-"+a[x])
+	}
+	for ; y < len(b); y++ {
+		diff = append(diff, "+"+b[y])
+	}
+	return diff
+}
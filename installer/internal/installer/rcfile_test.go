@@ -0,0 +1,225 @@
+package installer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReplaceManagedBlock(t *testing.T) {
+	block := func(lines ...string) string {
+		return strings.Join(append([]string{rcBlockBegin, rcBlockComment}, append(lines, rcBlockEnd)...), "\n") + "\n"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		lines   []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "empty file",
+			lines: []string{"export A=1"},
+			want:  block("export A=1"),
+		},
+		{
+			name:    "append after a separator",
+			content: "alias ll='ls -l'\n",
+			lines:   []string{"export A=1"},
+			want:    "alias ll='ls -l'\n\n" + block("export A=1"),
+		},
+		{
+			name:    "replace in place",
+			content: "before\n" + block("export A=0") + "after\n",
+			lines:   []string{"export A=1"},
+			want:    "before\n" + block("export A=1") + "after\n",
+		},
+		{
+			name:    "drop duplicate blocks",
+			content: block("export A=0") + "middle\n\n" + block("export A=0"),
+			lines:   []string{"export A=1"},
+			want:    block("export A=1") + "middle\n",
+		},
+		{
+			name:    "remove the block and its separator",
+			content: "alias ll='ls -l'\n\n" + block("export A=1"),
+			want:    "alias ll='ls -l'\n",
+		},
+		{
+			name:    "remove keeps the user's blank lines",
+			content: "one\n\n\n" + block("export A=1") + "two\n\n",
+			want:    "one\n\ntwo\n\n",
+		},
+		{
+			name:    "remove the only content",
+			content: block("export A=1"),
+			want:    "",
+		},
+		{
+			name:    "unchanged without block",
+			content: "one\n",
+			want:    "one\n",
+		},
+		{
+			name:    "unterminated block",
+			content: "one\n" + rcBlockBegin + "\nexport A=1\nalias ll='ls -l'\n",
+			lines:   []string{"export A=1"},
+			wantErr: true,
+		},
+		{
+			name:    "unterminated block on removal",
+			content: rcBlockBegin + "\nexport A=1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replaceManagedBlock(tt.content, tt.lines)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replaceManagedBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("replaceManagedBlock() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{
+			name: "equal",
+			a:    []string{"a", "b"},
+			b:    []string{"a", "b"},
+		},
+		{
+			name: "added",
+			a:    []string{"a"},
+			b:    []string{"a", "b"},
+			want: []string{"+b"},
+		},
+		{
+			name: "removed",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "c"},
+			want: []string{"-b"},
+		},
+		{
+			name: "changed",
+			a:    []string{"a", "old", "c"},
+			b:    []string{"a", "new", "c"},
+			want: []string{"-old", "+new"},
+		},
+		{
+			name: "from empty",
+			b:    []string{"a"},
+			want: []string{"+a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineDiff(tt.a, tt.b); !slices.Equal(got, tt.want) {
+				t.Errorf("lineDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteManagedBlock(t *testing.T) {
+	tests := []struct {
+		name string
+		// symlink makes the rc file a link to a file of a dotfiles directory.
+		symlink bool
+		lines   []string
+		want    string
+	}{
+		{
+			name:  "add",
+			lines: []string{"export A=1"},
+			want:  "alias ll='ls -l'\n\n" + rcBlockBegin + "\n" + rcBlockComment + "\nexport A=1\n" + rcBlockEnd + "\n",
+		},
+		{
+			name:    "add through a symlink",
+			symlink: true,
+			lines:   []string{"export A=1"},
+			want:    "alias ll='ls -l'\n\n" + rcBlockBegin + "\n" + rcBlockComment + "\nexport A=1\n" + rcBlockEnd + "\n",
+		},
+		{
+			name: "remove",
+			want: "alias ll='ls -l'\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			i := &Installer{StateDir: filepath.Join(home, "state")}
+			rcFile := filepath.Join(home, ".bashrc")
+			target := rcFile
+			if tt.symlink {
+				target = filepath.Join(home, "dotfiles", "bashrc")
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(target, rcFile); err != nil {
+					t.Skipf("cannot create symlinks: %v", err)
+				}
+			}
+			if err := os.WriteFile(target, []byte("alias ll='ls -l'\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.lines) == 0 {
+				if err := i.writeManagedBlock(context.Background(), rcFile, []string{"export A=1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := i.writeManagedBlock(context.Background(), rcFile, tt.lines); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("rc file =\n%q\nwant\n%q", data, tt.want)
+			}
+			if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("rc file mode = %v, %v, want 0600", info.Mode(), err)
+			}
+			if tt.symlink {
+				if info, err := os.Lstat(rcFile); err != nil || info.Mode()&os.ModeSymlink == 0 {
+					t.Errorf("rc file is no longer a symlink: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteManagedBlockKeepsEmptyFile(t *testing.T) {
+	home := t.TempDir()
+	i := &Installer{StateDir: filepath.Join(home, "state")}
+	rcFile := filepath.Join(home, "config.fish")
+	ctx := context.Background()
+
+	if err := i.writeManagedBlock(ctx, rcFile, []string{"set -gx A 1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.writeManagedBlock(ctx, rcFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(rcFile); err != nil || len(data) != 0 {
+		t.Errorf("rc file = %q, %v, want an empty file", data, err)
+	}
+}
@@ -0,0 +1,119 @@
+package installer
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version, see https://semver.org. Build
+// metadata is ignored as it does not take part in the precedence.
+type semver struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+func parseSemver(v string) (semver, error) {
+	var s semver
+	core, _, _ := strings.Cut(strings.TrimPrefix(v, "v"), "+")
+	core, prerelease, hasPrerelease := strings.Cut(core, "-")
+
+	fields := strings.Split(core, ".")
+	if len(fields) != 3 {
+		return s, fmt.Errorf("invalid version %q, expected major.minor.patch", v)
+	}
+	nums := make([]int, len(fields))
+	for n, field := range fields {
+		num, err := strconv.Atoi(field)
+		if err != nil || num < 0 {
+			return s, fmt.Errorf("invalid version %q, expected major.minor.patch", v)
+		}
+		nums[n] = num
+	}
+	s.major, s.minor, s.patch = nums[0], nums[1], nums[2]
+
+	if hasPrerelease {
+		s.prerelease = strings.Split(prerelease, ".")
+		for _, id := range s.prerelease {
+			if id == "" {
+				return s, fmt.Errorf("invalid pre-release in version %q", v)
+			}
+		}
+	}
+	return s, nil
+}
+
+// compare returns -1, 0 or 1 when s has a lower, equal or higher precedence
+// than o.
+func (s semver) compare(o semver) int {
+	if c := cmp.Compare(s.major, o.major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(s.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(s.patch, o.patch); c != 0 {
+		return c
+	}
+
+	// A version without pre-release has a higher precedence
+	switch {
+	case len(s.prerelease) == 0 && len(o.prerelease) == 0:
+		return 0
+	case len(s.prerelease) == 0:
+		return 1
+	case len(o.prerelease) == 0:
+		return -1
+	}
+
+	for n := 0; n < len(s.prerelease) && n < len(o.prerelease); n++ {
+		if c := comparePrerelease(s.prerelease[n], o.prerelease[n]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(s.prerelease), len(o.prerelease))
+}
+
+// comparePrerelease compares single pre-release identifiers: numeric
+// identifiers compare numerically and sort before alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// upstreamVersion returns the semantic version of a Debian package version:
+// the Debian revision, a suffix starting with a digit after the last hyphen
+// as in 1.0.0-1, is dropped and a ~ pre-release, which sorts before the
+// release in Debian, becomes a semantic version pre-release. Pre-releases
+// therefore have to start with a letter, e.g. 1.0.0-rc.1.
+func upstreamVersion(v string) string {
+	if n := strings.LastIndex(v, "-"); n >= 0 && n+1 < len(v) && v[n+1] >= '0' && v[n+1] <= '9' {
+		v = v[:n]
+	}
+	return strings.Replace(v, "~", "-", 1)
+}
+
+// compareVersions parses and compares two semantic or Debian package
+// versions. Debian revisions take no part in the comparison.
+func compareVersions(a, b string) (int, error) {
+	va, err := parseSemver(upstreamVersion(a))
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseSemver(upstreamVersion(b))
+	if err != nil {
+		return 0, err
+	}
+	return va.compare(vb), nil
+}
@@ -0,0 +1,92 @@
+package installer
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		version string
+		want    semver
+		wantErr bool
+	}{
+		{version: "1.2.3", want: semver{major: 1, minor: 2, patch: 3}},
+		{version: "v1.2.3", want: semver{major: 1, minor: 2, patch: 3}},
+		{version: "1.2.3+build.5", want: semver{major: 1, minor: 2, patch: 3}},
+		{version: "1.2.3-rc.1", want: semver{major: 1, minor: 2, patch: 3, prerelease: []string{"rc", "1"}}},
+		{version: "1.2.3-rc.1+build", want: semver{major: 1, minor: 2, patch: 3, prerelease: []string{"rc", "1"}}},
+		{version: "1.2", wantErr: true},
+		{version: "1.2.3.4", wantErr: true},
+		{version: "1.x.3", wantErr: true},
+		{version: "1.2.-3", wantErr: true},
+		{version: "1.2.3-rc..1", wantErr: true},
+		{version: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := parseSemver(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSemver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.major != tt.want.major || got.minor != tt.want.minor || got.patch != tt.want.patch ||
+				!slices.Equal(got.prerelease, tt.want.prerelease) {
+				t.Errorf("parseSemver() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.0.0", b: "1.0.0", want: 0},
+		{a: "1.0.1", b: "1.0.0", want: 1},
+		{a: "1.9.0", b: "1.10.0", want: -1},
+		{a: "2.0.0", b: "1.99.99", want: 1},
+		{a: "1.0.0-rc.1", b: "1.0.0", want: -1},
+		{a: "1.0.0-alpha", b: "1.0.0-alpha.1", want: -1},
+		{a: "1.0.0-alpha.1", b: "1.0.0-alpha.beta", want: -1},
+		{a: "1.0.0-rc.2", b: "1.0.0-rc.10", want: -1},
+		{a: "1.0.0+build.1", b: "1.0.0+build.2", want: 0},
+		// Debian package versions
+		{a: "1.0.0-1", b: "1.0.0", want: 0},
+		{a: "1.0.0-2", b: "1.0.0-1", want: 0},
+		{a: "1.0.1-1", b: "1.0.0", want: 1},
+		{a: "1.0.0-0ubuntu1", b: "1.0.0", want: 0},
+		{a: "1.0.0~rc1", b: "1.0.0", want: -1},
+		{a: "1.0.0~rc1-1", b: "1.0.0-1", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			got, err := compareVersions(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if back, _ := compareVersions(tt.b, tt.a); back != -tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, back, -tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinationForSkipsChangelog(t *testing.T) {
+	i := &Installer{TargetDir: "/target", BinDir: "/bin", ManDir: "/man"}
+	if _, _, ok, err := i.destinationFor("changelog"); ok || err != nil {
+		t.Errorf("destinationFor(changelog) = %v, %v, want the changelog not to be installed", ok, err)
+	}
+	if dst, _, ok, _ := i.destinationFor("utils/logger.sh"); !ok || dst != filepath.Join("/target", "utils", "logger.sh") {
+		t.Errorf("destinationFor(utils/logger.sh) = %q, %v", dst, ok)
+	}
+}
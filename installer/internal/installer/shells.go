@@ -0,0 +1,214 @@
+package installer
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shellEnv writes the PATH and MAMBA_GITHOOK_DIR settings in the syntax of a
+// single shell.
+type shellEnv interface {
+	// ConfigFile returns the startup file of the shell relative to homeDir.
+	ConfigFile(homeDir string) string
+	// Lines returns the statements that append binDir to PATH and set
+	// MAMBA_GITHOOK_DIR to targetDir.
+	Lines(binDir, targetDir string) []string
+	// Setenv returns the statement that exports an environment variable.
+	Setenv(name, value string) string
+}
+
+var shellEnvs = map[string]shellEnv{
+	"bash":  posixShell{rcFile: ".bashrc"},
+	"zsh":   posixShell{rcFile: ".zshrc"},
+	"sh":    posixShell{rcFile: ".profile"},
+	"fish":  fishShell{},
+	"tcsh":  cshShell{rcFile: ".tcshrc"},
+	"csh":   cshShell{rcFile: ".cshrc"},
+	"nu":    nuShell{},
+	"pwsh":  powerShell{},
+	"xonsh": xonshShell{},
+}
+
+var shellAliases = map[string]string{
+	"nushell":    "nu",
+	"powershell": "pwsh",
+	"dash":       "sh",
+	"ksh":        "sh",
+}
+
+// lookupShell returns the environment writer of a shell by name.
+func lookupShell(name string) (shellEnv, error) {
+	if alias, ok := shellAliases[name]; ok {
+		name = alias
+	}
+	sh, ok := shellEnvs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported shell %q, supported shells: %s", name, strings.Join(supportedShells(), ", "))
+	}
+	return sh, nil
+}
+
+func supportedShells() []string {
+	names := make([]string, 0, len(shellEnvs))
+	for name := range shellEnvs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// shells returns the shells to configure: the ones requested explicitly or
+// the login shell detected from $SHELL.
+func (i *Installer) shells() []string {
+	if len(i.Shells) > 0 {
+		return i.Shells
+	}
+	shell := detectShell()
+	if _, ok := shellEnvs[shell]; !ok {
+		if _, ok := shellAliases[shell]; !ok {
+			// Unknown shells fall back to ~/.profile
+			shell = "sh"
+		}
+	}
+	return []string{shell}
+}
+
+type posixShell struct {
+	rcFile string
+}
+
+func (s posixShell) ConfigFile(homeDir string) string {
+	return filepath.Join(homeDir, s.rcFile)
+}
+
+func (s posixShell) Lines(binDir, targetDir string) []string {
+	return []string{
+		fmt.Sprintf("export PATH=\"$PATH:%s\"", posixEscape(binDir)),
+		s.Setenv("MAMBA_GITHOOK_DIR", targetDir),
+	}
+}
+
+func (posixShell) Setenv(name, value string) string {
+	return fmt.Sprintf("export %s=\"%s\"", name, posixEscape(value))
+}
+
+type fishShell struct{}
+
+func (fishShell) ConfigFile(homeDir string) string {
+	return filepath.Join(homeDir, ".config", "fish", "config.fish")
+}
+
+func (s fishShell) Lines(binDir, targetDir string) []string {
+	return []string{
+		fmt.Sprintf("set -gx PATH $PATH %s", fishQuote(binDir)),
+		s.Setenv("MAMBA_GITHOOK_DIR", targetDir),
+	}
+}
+
+func (fishShell) Setenv(name, value string) string {
+	return fmt.Sprintf("set -gx %s %s", name, fishQuote(value))
+}
+
+type cshShell struct {
+	rcFile string
+}
+
+func (s cshShell) ConfigFile(homeDir string) string {
+	return filepath.Join(homeDir, s.rcFile)
+}
+
+func (s cshShell) Lines(binDir, targetDir string) []string {
+	return []string{
+		fmt.Sprintf("setenv PATH \"${PATH}\":%s", singleQuote(binDir, posixQuoteEscape)),
+		s.Setenv("MAMBA_GITHOOK_DIR", targetDir),
+	}
+}
+
+func (cshShell) Setenv(name, value string) string {
+	// csh expands $ even in double quotes, only single quotes are literal
+	return fmt.Sprintf("setenv %s %s", name, singleQuote(value, posixQuoteEscape))
+}
+
+type nuShell struct{}
+
+func (nuShell) ConfigFile(homeDir string) string {
+	return filepath.Join(homeDir, ".config", "nushell", "env.nu")
+}
+
+func (s nuShell) Lines(binDir, targetDir string) []string {
+	return []string{
+		fmt.Sprintf("$env.PATH = ($env.PATH | split row (char esep) | append %s)", strconv.Quote(binDir)),
+		s.Setenv("MAMBA_GITHOOK_DIR", targetDir),
+	}
+}
+
+func (nuShell) Setenv(name, value string) string {
+	return fmt.Sprintf("$env.%s = %s", name, strconv.Quote(value))
+}
+
+type powerShell struct{}
+
+func (powerShell) ConfigFile(homeDir string) string {
+	return filepath.Join(homeDir, ".config", "powershell", "Microsoft.PowerShell_profile.ps1")
+}
+
+func (s powerShell) Lines(binDir, targetDir string) []string {
+	return []string{
+		fmt.Sprintf("$env:PATH = $env:PATH + [IO.Path]::PathSeparator + %s", singleQuote(binDir, "''")),
+		s.Setenv("MAMBA_GITHOOK_DIR", targetDir),
+	}
+}
+
+func (powerShell) Setenv(name, value string) string {
+	return fmt.Sprintf("$env:%s = %s", name, singleQuote(value, "''"))
+}
+
+type xonshShell struct{}
+
+func (xonshShell) ConfigFile(homeDir string) string {
+	return filepath.Join(homeDir, ".xonshrc")
+}
+
+func (s xonshShell) Lines(binDir, targetDir string) []string {
+	return []string{
+		fmt.Sprintf("$PATH.append(%s)", strconv.Quote(binDir)),
+		s.Setenv("MAMBA_GITHOOK_DIR", targetDir),
+	}
+}
+
+func (xonshShell) Setenv(name, value string) string {
+	return fmt.Sprintf("$%s = %s", name, strconv.Quote(value))
+}
+
+// envLines returns the statements of the managed block of a shell.
+func (i *Installer) envLines(sh shellEnv) []string {
+	lines := sh.Lines(i.unrooted(i.BinDir), i.unrooted(i.TargetDir))
+	if i.MicromambaDir != "" {
+		lines = append(lines, sh.Setenv(micromambaDirEnv, i.MicromambaDir))
+	}
+	return lines
+}
+
+// posixEscape escapes s for use inside a double quoted POSIX string.
+func posixEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+}
+
+// posixQuoteEscape ends a single quoted POSIX or csh string, adds an
+// escaped single quote and starts a new one.
+const posixQuoteEscape = `'\''`
+
+// singleQuote wraps s in single quotes, replacing embedded single quotes
+// with escaped.
+func singleQuote(s, escaped string) string {
+	return "'" + strings.ReplaceAll(s, "'", escaped) + "'"
+}
+
+// fishQuote single quotes s for fish, where backslashes escape inside single
+// quotes too.
+func fishQuote(s string) string {
+	return singleQuote(strings.ReplaceAll(s, `\`, `\\`), `\'`)
+}
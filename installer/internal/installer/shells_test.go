@@ -0,0 +1,120 @@
+package installer
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestShellEnvQuoting(t *testing.T) {
+	values := []string{"/opt/my tools", "/home/o'brien", "/tmp/$HOME", `C:\bin`}
+
+	tests := []struct {
+		shell string
+		// setenv are the statements exporting X with each of values.
+		setenv []string
+		// path appends the first value to PATH.
+		path string
+	}{
+		{
+			shell: "bash",
+			setenv: []string{
+				`export X="/opt/my tools"`,
+				`export X="/home/o'brien"`,
+				`export X="/tmp/\$HOME"`,
+				`export X="C:\\bin"`,
+			},
+			path: `export PATH="$PATH:/opt/my tools"`,
+		},
+		{
+			shell: "fish",
+			setenv: []string{
+				`set -gx X '/opt/my tools'`,
+				`set -gx X '/home/o\'brien'`,
+				`set -gx X '/tmp/$HOME'`,
+				`set -gx X 'C:\\bin'`,
+			},
+			path: `set -gx PATH $PATH '/opt/my tools'`,
+		},
+		{
+			shell: "tcsh",
+			setenv: []string{
+				`setenv X '/opt/my tools'`,
+				`setenv X '/home/o'\''brien'`,
+				`setenv X '/tmp/$HOME'`,
+				`setenv X 'C:\bin'`,
+			},
+			path: `setenv PATH "${PATH}":'/opt/my tools'`,
+		},
+		{
+			shell: "nu",
+			setenv: []string{
+				`$env.X = "/opt/my tools"`,
+				`$env.X = "/home/o'brien"`,
+				`$env.X = "/tmp/$HOME"`,
+				`$env.X = "C:\\bin"`,
+			},
+			path: `$env.PATH = ($env.PATH | split row (char esep) | append "/opt/my tools")`,
+		},
+		{
+			shell: "pwsh",
+			setenv: []string{
+				`$env:X = '/opt/my tools'`,
+				`$env:X = '/home/o''brien'`,
+				`$env:X = '/tmp/$HOME'`,
+				`$env:X = 'C:\bin'`,
+			},
+			path: `$env:PATH = $env:PATH + [IO.Path]::PathSeparator + '/opt/my tools'`,
+		},
+		{
+			shell: "xonsh",
+			setenv: []string{
+				`$X = "/opt/my tools"`,
+				`$X = "/home/o'brien"`,
+				`$X = "/tmp/$HOME"`,
+				`$X = "C:\\bin"`,
+			},
+			path: `$PATH.append("/opt/my tools")`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			sh, err := lookupShell(tt.shell)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for n, value := range values {
+				if got := sh.Setenv("X", value); got != tt.setenv[n] {
+					t.Errorf("Setenv(%q) = %s, want %s", value, got, tt.setenv[n])
+				}
+			}
+			lines := sh.Lines(values[0], values[1])
+			if len(lines) != 2 || lines[0] != tt.path || lines[1] != sh.Setenv("MAMBA_GITHOOK_DIR", values[1]) {
+				t.Errorf("Lines() = %q, want %s first", lines, tt.path)
+			}
+
+			// Check the statements with the shell itself when it is installed
+			shell := tt.shell
+			if tt.shell == "bash" {
+				shell = "sh"
+			}
+			if _, err := exec.LookPath(shell); err != nil {
+				return
+			}
+			for n, value := range values {
+				script := tt.setenv[n] + "\nprintf '%s\\n' \"$X\"\n"
+				if shell == "pwsh" {
+					script = tt.setenv[n] + "\nWrite-Output $env:X\n"
+				}
+				output, err := exec.Command(shell, "-c", script).Output()
+				if err != nil {
+					t.Fatalf("%s -c %q failed: %v", shell, script, err)
+				}
+				if got := strings.TrimSuffix(string(output), "\n"); got != value {
+					t.Errorf("%s sets X to %q, want %q", shell, got, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrDegraded is returned by Status when the installation is missing or not
+// fully working.
+var ErrDegraded = errors.New("mamba-githook installation is degraded")
+
+// StatusReport describes the state of an installation.
+type StatusReport struct {
+	Installed        bool           `json:"installed" yaml:"installed"`
+	InstallerVersion string         `json:"installer_version,omitempty" yaml:"installer_version,omitempty"`
+	InstalledAt      *time.Time     `json:"installed_at,omitempty" yaml:"installed_at,omitempty"`
+	Manifest         ManifestStatus `json:"manifest" yaml:"manifest"`
+	Binary           FileStatus     `json:"binary" yaml:"binary"`
+	Shells           []ShellStatus  `json:"shells" yaml:"shells"`
+	GitHooks         GitHooksStatus `json:"git_hooks" yaml:"git_hooks"`
+	Micromamba       FileStatus     `json:"micromamba" yaml:"micromamba"`
+	Backup           FileStatus     `json:"backup" yaml:"backup"`
+	Problems         []string       `json:"problems" yaml:"problems"`
+}
+
+// ManifestStatus reports the integrity of the installed files.
+type ManifestStatus struct {
+	Path     string   `json:"path" yaml:"path"`
+	Present  bool     `json:"present" yaml:"present"`
+	Files    int      `json:"files" yaml:"files"`
+	Missing  []string `json:"missing,omitempty" yaml:"missing,omitempty"`
+	Modified []string `json:"modified,omitempty" yaml:"modified,omitempty"`
+	// Customized lists locally modified files kept by an upgrade.
+	Customized []string `json:"customized,omitempty" yaml:"customized,omitempty"`
+}
+
+// FileStatus reports whether a file or directory exists.
+type FileStatus struct {
+	Path    string `json:"path" yaml:"path"`
+	Present bool   `json:"present" yaml:"present"`
+}
+
+// ShellStatus reports the environment configured for one shell.
+type ShellStatus struct {
+	Shell              string `json:"shell" yaml:"shell"`
+	ConfigFile         string `json:"config_file" yaml:"config_file"`
+	Blocks             int    `json:"blocks" yaml:"blocks"`
+	PathSet            bool   `json:"path_set" yaml:"path_set"`
+	MambaGithookDirSet bool   `json:"mamba_githook_dir_set" yaml:"mamba_githook_dir_set"`
+}
+
+// GitHooksStatus reports the effective core.hooksPath values.
+type GitHooksStatus struct {
+	Expected string `json:"expected" yaml:"expected"`
+	// Global is the value in the scope configured by the installation, the
+	// system config for system installations.
+	Global        string `json:"global" yaml:"global"`
+	GlobalCorrect bool   `json:"global_correct" yaml:"global_correct"`
+	Local         string `json:"local,omitempty" yaml:"local,omitempty"`
+}
+
+// Degraded reports whether any problem was found.
+func (r *StatusReport) Degraded() bool {
+	return len(r.Problems) > 0
+}
+
+func (r *StatusReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// StatusReport inspects the installation without changing anything.
+func (i *Installer) StatusReport(ctx context.Context) (*StatusReport, error) {
+	report := &StatusReport{
+		Manifest: ManifestStatus{Path: i.manifestPath()},
+		Binary:   FileStatus{Path: filepath.Join(i.BinDir, "mamba-githook")},
+		Backup:   FileStatus{Path: i.BackupDir},
+		Shells:   []ShellStatus{},
+		Problems: []string{},
+	}
+
+	manifest, err := i.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		// The expected hooks path depends on the chaining and hooks installed
+		i.useManifestSettings(manifest)
+	}
+
+	report.GitHooks, err = i.checkGitHooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Micromamba = i.findMicromamba()
+	backups, err := i.Backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Backup.Present = len(backups) > 0
+
+	_, err = os.Stat(i.TargetDir)
+	report.Installed = manifest != nil || err == nil
+	if !report.Installed {
+		report.problem("mamba-githook is not installed")
+		return report, nil
+	}
+
+	shells := i.shells()
+	if manifest == nil {
+		report.problem("mamba-githook is installed but has no install manifest, reinstall to create one")
+	} else {
+		report.InstallerVersion = manifest.InstallerVersion
+		report.InstalledAt = &manifest.InstalledAt
+		report.Manifest.Present = true
+		report.Manifest.Files = len(manifest.Files)
+		i.checkManifestFiles(manifest, report)
+		shells = mergeShells(manifest.Shells, i.Shells)
+	}
+
+	_, err = os.Stat(report.Binary.Path)
+	report.Binary.Present = err == nil
+	if !report.Binary.Present {
+		report.problem("mamba-githook binary is missing: %s", report.Binary.Path)
+	}
+
+	statuses, err := i.checkEnvVars(ctx, shells)
+	if err != nil {
+		return nil, err
+	}
+	report.Shells = append(report.Shells, statuses...)
+	for _, shell := range report.Shells {
+		if shell.Blocks > 1 {
+			report.problem("%s contains %d mamba-githook blocks, reinstall to merge them", shell.ConfigFile, shell.Blocks)
+		}
+		if !shell.PathSet {
+			report.problem("mamba-githook directory is not in PATH for %s", shell.Shell)
+		}
+		if !shell.MambaGithookDirSet {
+			report.problem("MAMBA_GITHOOK_DIR is not set correctly for %s", shell.Shell)
+		}
+	}
+
+	// With the repo hooks scope core.hooksPath is set by each repository
+	if i.HooksScope != HooksScopeRepo {
+		if !report.GitHooks.GlobalCorrect {
+			report.problem("Git hooks path is not set correctly. Expected: %s, Got: %s", report.GitHooks.Expected, report.GitHooks.Global)
+		}
+		if report.GitHooks.Local != "" && !i.isOwnHooksPath(report.GitHooks.Local) {
+			report.problem("Local core.hooksPath %s overrides the global hooks in this repository", report.GitHooks.Local)
+		}
+	}
+
+	return report, nil
+}
+
+// checkManifestFiles records installed files that are missing or no longer
+// match their recorded checksum.
+func (i *Installer) checkManifestFiles(m *Manifest, report *StatusReport) {
+	for _, file := range m.Files {
+		ok, err := file.verify()
+		switch {
+		case os.IsNotExist(err):
+			report.Manifest.Missing = append(report.Manifest.Missing, file.Path)
+			report.problem("Installed file is missing: %s", file.Path)
+		case err != nil:
+			report.problem("Failed to verify installed file %s: %v", file.Path, err)
+		case !ok && file.Customized:
+			report.Manifest.Customized = append(report.Manifest.Customized, file.Path)
+		case !ok:
+			report.Manifest.Modified = append(report.Manifest.Modified, file.Path)
+			report.problem("Installed file has been modified: %s", file.Path)
+		}
+	}
+}
+
+// micromambaDirEnv is the variable the hooks read the micromamba directory
+// from.
+const micromambaDirEnv = "MICROMAMBA_BIN_FOLDER"
+
+// defaultMicromambaDir returns the directory the hooks install micromamba
+// into when no directory is configured.
+func (i *Installer) defaultMicromambaDir() string {
+	if dir := os.Getenv(micromambaDirEnv); dir != "" {
+		return dir
+	}
+	return filepath.Join(i.HomeDir, ".local", "bin")
+}
+
+// findMicromamba looks for micromamba in the configured directory, on PATH
+// and in the default location used by mamba-githook.
+func (i *Installer) findMicromamba() FileStatus {
+	if i.MicromambaDir != "" {
+		path := filepath.Join(i.rooted(i.MicromambaDir), "micromamba")
+		_, err := os.Stat(path)
+		return FileStatus{Path: path, Present: err == nil}
+	}
+	if path, err := exec.LookPath("micromamba"); err == nil {
+		return FileStatus{Path: path, Present: true}
+	}
+
+	path := filepath.Join(i.defaultMicromambaDir(), "micromamba")
+	_, err := os.Stat(path)
+	return FileStatus{Path: path, Present: err == nil}
+}
+
+// installMicromamba installs micromamba with the installed mamba-githook
+// tool.
+func (i *Installer) installMicromamba(ctx context.Context) error {
+	binary := filepath.Join(i.BinDir, "mamba-githook")
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "run", Target: binary, Detail: "install-micromamba -y"})
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, binary, "install-micromamba", "-y")
+	cmd.Env = append(os.Environ(), "MAMBA_GITHOOK_DIR="+i.TargetDir)
+	if i.MicromambaDir != "" {
+		cmd.Env = append(cmd.Env, micromambaDirEnv+"="+i.MicromambaDir)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Status checks the installation and prints the report in the given output
+// format: text (log messages), json or yaml. ErrDegraded is returned when
+// any problem was found.
+func (i *Installer) Status(ctx context.Context, output string, w io.Writer) error {
+	ctx = log.WithOperation(ctx, "status")
+	if output == "text" {
+		log.Ctx(ctx).Info().Msg("Checking mamba-githook installation status")
+	}
+
+	report, err := i.StatusReport(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch output {
+	case "text":
+		logStatusReport(ctx, report)
+	default:
+		if err := encodeOutput(w, output, report); err != nil {
+			return fmt.Errorf("failed to encode status: %w", err)
+		}
+	}
+
+	if report.Degraded() {
+		return ErrDegraded
+	}
+	return nil
+}
+
+// encodeOutput writes v to w as json or yaml.
+func encodeOutput(w io.Writer, output string, v any) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported output format %q, expected text, json or yaml", output)
+	}
+}
+
+func logStatusReport(ctx context.Context, report *StatusReport) {
+	if !report.Installed {
+		log.Ctx(ctx).Info().Msg("mamba-githook is not installed")
+		return
+	}
+
+	if report.InstalledAt != nil {
+		log.Ctx(ctx).Info().Msgf("Installed by installer version %s at %s",
+			report.InstallerVersion, report.InstalledAt.Format(time.RFC3339))
+	}
+	for _, problem := range report.Problems {
+		log.Ctx(ctx).Warn().Msg(problem)
+	}
+	if !report.Micromamba.Present {
+		log.Ctx(ctx).Info().Msg("micromamba is not installed yet, it is installed on the first hook run")
+	}
+
+	if !report.Degraded() {
+		log.Ctx(ctx).Info().Msg("mamba-githook is properly installed and configured")
+	}
+}
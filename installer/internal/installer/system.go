@@ -0,0 +1,131 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// systemPrefix is the default prefix of a system installation.
+	systemPrefix = "/usr/local"
+	// profileSnippet sets up the environment of every user's login shell in
+	// a system installation.
+	profileSnippet = "/etc/profile.d/mamba-githook.sh"
+	// systemGitConfig is the system git config file written when installing
+	// into a root directory.
+	systemGitConfig = "/etc/gitconfig"
+	// systemConfigDir holds the config file and layout of a system
+	// installation.
+	systemConfigDir = "/etc/mamba-githook"
+)
+
+// UseSystemLayout switches to the directories of a system installation
+// below root, which is empty for the real root directory. It is called
+// before ApplyLayout.
+func (i *Installer) UseSystemLayout(root string) error {
+	if i.OS == "windows" {
+		return fmt.Errorf("system installation is not supported on Windows")
+	}
+	if root != "" {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return fmt.Errorf("invalid root %q: %w", root, err)
+		}
+		root = abs
+	}
+
+	i.System = true
+	i.Root = root
+	i.TargetDir = i.rooted(filepath.Join(systemPrefix, "share", "mamba-githook"))
+	i.BinDir = i.rooted(filepath.Join(systemPrefix, "bin"))
+	i.ManDir = i.rooted(filepath.Join(systemPrefix, "share", "man", "man1"))
+	i.BackupDir = i.rooted("/var/backups/mamba-githook")
+	i.StateDir = i.rooted("/var/lib/mamba-githook")
+	i.ConfigDir = i.rooted(systemConfigDir)
+	return nil
+}
+
+// HasSystemLayoutOnly reports whether the only installation recorded is a
+// system installation: no user layout exists but the system layout does.
+// Commands acting on an existing installation then switch to the system
+// layout.
+func (i *Installer) HasSystemLayoutOnly() bool {
+	if i.System || i.OS == "windows" {
+		return false
+	}
+	if _, err := os.Stat(i.layoutPath()); !os.IsNotExist(err) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(systemConfigDir, layoutFileName))
+	return err == nil
+}
+
+// rooted returns the location of an absolute path below Root.
+func (i *Installer) rooted(path string) string {
+	if i.Root == "" || path == "" {
+		return path
+	}
+	return filepath.Join(i.Root, path)
+}
+
+// unrooted returns the path a file below Root has once the root directory
+// is packaged, which is the path written into files and the git config.
+func (i *Installer) unrooted(path string) string {
+	if i.Root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(i.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(string(filepath.Separator), rel)
+}
+
+// gitScope returns the git config scope of core.hooksPath: global for user
+// installations, system otherwise. Below a root directory the system config
+// file of the root is written instead.
+func (i *Installer) gitScope() string {
+	switch {
+	case !i.System:
+		return "global"
+	case i.Root != "":
+		return "file:" + i.rooted(systemGitConfig)
+	default:
+		return "system"
+	}
+}
+
+// gitScopeArgs returns the git config options selecting a scope.
+func gitScopeArgs(scope string) []string {
+	if file, ok := strings.CutPrefix(scope, "file:"); ok {
+		return []string{"--file", file}
+	}
+	return []string{"--" + scope}
+}
+
+func (i *Installer) profileSnippetPath() string {
+	return i.rooted(profileSnippet)
+}
+
+func (i *Installer) setupSystemEnvironment(ctx context.Context) error {
+	if err := i.writeManagedBlock(ctx, i.profileSnippetPath(), i.envLines(shellEnvs["sh"])); err != nil {
+		return fmt.Errorf("failed to configure %s: %w", profileSnippet, err)
+	}
+	return nil
+}
+
+func (i *Installer) removeSystemEnvironment(ctx context.Context) error {
+	if err := i.writeManagedBlock(ctx, i.profileSnippetPath(), nil); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", profileSnippet, err)
+	}
+	return nil
+}
+
+// checkSystemEnvVars reports whether the profile snippet sets PATH and
+// MAMBA_GITHOOK_DIR as the installer would.
+func (i *Installer) checkSystemEnvVars(ctx context.Context) (ShellStatus, error) {
+	return checkManagedBlock("profile", i.profileSnippetPath(), i.envLines(shellEnvs["sh"]))
+}
@@ -0,0 +1,321 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/aydabd/mamba-githook/installer/internal/log"
+)
+
+const journalFileName = "journal.json"
+
+type stepKind string
+
+const (
+	stepCreateFile  stepKind = "create_file"
+	stepReplaceFile stepKind = "replace_file"
+	stepCreateDir   stepKind = "create_dir"
+	stepGitConfig   stepKind = "git_config"
+	stepSetEnv      stepKind = "set_env"
+)
+
+// journalStep is a single completed change that can be undone.
+type journalStep struct {
+	Kind stepKind `json:"kind"`
+	// Path is the file or directory that was created or replaced.
+	Path string `json:"path,omitempty"`
+	// Backup is the copy of a replaced file kept inside the transaction directory.
+	Backup string `json:"backup,omitempty"`
+	// Scope and Key identify a git config entry, Key alone a user environment variable.
+	Scope string `json:"scope,omitempty"`
+	Key   string `json:"key,omitempty"`
+	// Previous holds the value of a git config entry or environment variable
+	// before it was changed, HadPrevious whether it was set at all.
+	Previous    string `json:"previous,omitempty"`
+	HadPrevious bool   `json:"had_previous,omitempty"`
+}
+
+// transaction journals every change made by an installation so that it can
+// be undone if a later step fails. The journal is persisted after each step,
+// which lets the next run roll back an installation that crashed.
+type transaction struct {
+	dir       string
+	StartedAt time.Time     `json:"started_at"`
+	Steps     []journalStep `json:"steps"`
+}
+
+func (i *Installer) transactionDir() string {
+	return filepath.Join(i.StateDir, "transaction")
+}
+
+// beginTransaction rolls back any interrupted installation and starts a new
+// transaction.
+func (i *Installer) beginTransaction(ctx context.Context) error {
+	if err := i.recoverTransaction(ctx); err != nil {
+		return err
+	}
+	if i.DryRun {
+		return nil
+	}
+
+	tx := &transaction{dir: i.transactionDir(), StartedAt: time.Now().UTC()}
+	if err := os.MkdirAll(tx.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create transaction directory: %w", err)
+	}
+	if err := tx.save(); err != nil {
+		return err
+	}
+	i.tx = tx
+	return nil
+}
+
+// recoverTransaction rolls back a transaction left behind by a crashed run.
+func (i *Installer) recoverTransaction(ctx context.Context) (err error) {
+	data, err := os.ReadFile(filepath.Join(i.transactionDir(), journalFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read transaction journal: %w", err)
+	}
+
+	tx := &transaction{dir: i.transactionDir()}
+	if err := json.Unmarshal(data, tx); err != nil {
+		return fmt.Errorf("failed to parse transaction journal: %w", err)
+	}
+
+	if i.DryRun {
+		i.plan.add(PlanAction{
+			Op:     "rollback",
+			Target: filepath.Join(tx.dir, journalFileName),
+			Detail: fmt.Sprintf("%d steps of interrupted installation", len(tx.Steps)),
+		})
+		return nil
+	}
+
+	ctx, end := i.startAudit(ctx, "recover")
+	defer end(&err)
+	log.Ctx(ctx).Warn().Msgf("Rolling back interrupted installation started at %s", tx.StartedAt.Format(time.RFC3339))
+	if err := tx.rollback(context.WithoutCancel(ctx), i.auditUndo); err != nil {
+		return fmt.Errorf("failed to roll back interrupted installation: %w", err)
+	}
+	return nil
+}
+
+func (i *Installer) commitTransaction() error {
+	if i.tx == nil {
+		return nil
+	}
+	tx := i.tx
+	i.tx = nil
+	return tx.commit()
+}
+
+func (i *Installer) rollbackTransaction(ctx context.Context) error {
+	if i.tx == nil {
+		return nil
+	}
+	tx := i.tx
+	i.tx = nil
+	log.Ctx(ctx).Warn().Msg("Rolling back installation")
+	// The rollback has to complete even when the installation was cancelled
+	return tx.rollback(context.WithoutCancel(ctx), i.auditUndo)
+}
+
+func (tx *transaction) save() error {
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode transaction journal: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(tx.dir, "journal-*")
+	if err != nil {
+		return fmt.Errorf("failed to write transaction journal: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write transaction journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync transaction journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write transaction journal: %w", err)
+	}
+	return os.Rename(tmpPath, filepath.Join(tx.dir, journalFileName))
+}
+
+func (tx *transaction) record(step journalStep) error {
+	tx.Steps = append(tx.Steps, step)
+	return tx.save()
+}
+
+// rollback undoes all recorded steps in reverse order, passing each undone
+// step to undone, and removes the journal. Every step is attempted even if
+// an earlier undo fails.
+func (tx *transaction) rollback(ctx context.Context, undone func(journalStep)) error {
+	var errs []error
+	for j := len(tx.Steps) - 1; j >= 0; j-- {
+		step := tx.Steps[j]
+		log.Ctx(ctx).Debug().Msgf("Undoing %s %s%s", step.Kind, step.Path, step.Key)
+		if err := step.undo(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to undo %s: %w", step.Kind, err))
+			continue
+		}
+		undone(step)
+	}
+	if len(errs) > 0 {
+		// Keep the journal so the rollback can be retried on the next run.
+		return errors.Join(errs...)
+	}
+	return tx.commit()
+}
+
+func (tx *transaction) commit() error {
+	if err := os.RemoveAll(tx.dir); err != nil {
+		return fmt.Errorf("failed to remove transaction journal: %w", err)
+	}
+	return nil
+}
+
+func (s journalStep) undo(ctx context.Context) error {
+	switch s.Kind {
+	case stepCreateFile:
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	case stepReplaceFile:
+		if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+			return err
+		}
+		return copyFileMode(s.Backup, s.Path)
+	case stepCreateDir:
+		// Only remove the directory if nothing else has been put into it.
+		entries, err := os.ReadDir(s.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return os.Remove(s.Path)
+		}
+	case stepGitConfig:
+		if s.HadPrevious {
+			return gitConfigSet(ctx, s.Scope, s.Key, s.Previous)
+		}
+		return gitConfigUnset(ctx, s.Scope, s.Key)
+	case stepSetEnv:
+		if s.HadPrevious {
+			return setUserEnv(ctx, s.Key, s.Previous)
+		}
+		return unsetUserEnv(ctx, s.Key)
+	default:
+		return fmt.Errorf("unknown journal step %q", s.Kind)
+	}
+	return nil
+}
+
+// journalFileWrite records that path is about to be created or replaced. An
+// existing file is copied into the transaction directory first.
+func (i *Installer) journalFileWrite(ctx context.Context, path string) error {
+	if i.tx == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return i.tx.record(journalStep{Kind: stepCreateFile, Path: path})
+	} else if err != nil {
+		return err
+	}
+
+	backup := filepath.Join(i.tx.dir, "files", strconv.Itoa(len(i.tx.Steps)))
+	if err := os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+		return err
+	}
+	if err := copyFileMode(path, backup); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return i.tx.record(journalStep{Kind: stepReplaceFile, Path: path, Backup: backup})
+}
+
+// mkdirAll creates dir and any missing parents, journaling each directory it
+// creates.
+func (i *Installer) mkdirAll(ctx context.Context, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if i.DryRun {
+		for j := len(missing) - 1; j >= 0; j-- {
+			if !i.plan.created[missing[j]] {
+				i.plan.add(PlanAction{Op: "mkdir", Target: missing[j], Mode: fs.ModeDir | 0755})
+				i.plan.created[missing[j]] = true
+			}
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	i.createdDirs = append(i.createdDirs, missing...)
+
+	if i.tx == nil {
+		return nil
+	}
+	for j := len(missing) - 1; j >= 0; j-- {
+		if err := i.tx.record(journalStep{Kind: stepCreateDir, Path: missing[j]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalGitConfig records the current value of a git config entry before
+// it is changed.
+func (i *Installer) journalGitConfig(ctx context.Context, scope, key string) error {
+	if i.tx == nil {
+		return nil
+	}
+	value, ok, err := gitConfigGet(ctx, scope, key)
+	if err != nil {
+		return err
+	}
+	return i.tx.record(journalStep{Kind: stepGitConfig, Scope: scope, Key: key, Previous: value, HadPrevious: ok})
+}
+
+// journalUserEnv records the current value of a user environment variable
+// before it is changed.
+func (i *Installer) journalUserEnv(ctx context.Context, key string) error {
+	if i.tx == nil {
+		return nil
+	}
+	value, ok, err := getUserEnv(ctx, key)
+	if err != nil {
+		return err
+	}
+	return i.tx.record(journalStep{Kind: stepSetEnv, Key: key, Previous: value, HadPrevious: ok})
+}
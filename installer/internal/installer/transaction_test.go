@@ -0,0 +1,144 @@
+package installer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// undo undoes the changes of the transaction of i.
+		undo func(ctx context.Context, i *Installer) error
+		// wantAudit is whether the undone changes are recorded in the audit log.
+		wantAudit bool
+	}{
+		{
+			name: "rollback",
+			undo: func(ctx context.Context, i *Installer) error {
+				return i.rollbackTransaction(ctx)
+			},
+		},
+		{
+			name: "recover after crash",
+			undo: func(ctx context.Context, i *Installer) error {
+				// The next run only finds the journal left behind
+				next := &Installer{StateDir: i.StateDir}
+				return next.recoverTransaction(ctx)
+			},
+			wantAudit: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			root := t.TempDir()
+			i := &Installer{StateDir: filepath.Join(root, "state")}
+
+			replaced := filepath.Join(root, "replaced")
+			if err := os.WriteFile(replaced, []byte("original"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			newDir := filepath.Join(root, "new", "nested")
+			created := filepath.Join(newDir, "created")
+
+			if err := i.beginTransaction(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := i.mkdirAll(ctx, newDir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := i.writeFile(ctx, created, strings.NewReader("new"), 0644, "test"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := i.writeFile(ctx, replaced, strings.NewReader("changed"), 0755, "test"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.undo(ctx, i); err != nil {
+				t.Fatalf("undo failed: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "new")); !os.IsNotExist(err) {
+				t.Errorf("created directories not removed: %v", err)
+			}
+			data, err := os.ReadFile(replaced)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "original" {
+				t.Errorf("replaced file = %q, want %q", data, "original")
+			}
+			if info, err := os.Stat(replaced); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("replaced file mode not restored: %v %v", info.Mode(), err)
+			}
+			if _, err := os.Stat(i.transactionDir()); !os.IsNotExist(err) {
+				t.Errorf("transaction journal not removed: %v", err)
+			}
+
+			if !tt.wantAudit {
+				return
+			}
+			records, err := i.AuditRecords(ctx, AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 || records[0].Operation != "recover" ||
+				!slices.Contains(records[0].Files, created) || !slices.Contains(records[0].Files, replaced) {
+				t.Errorf("audit records = %+v, want the undone files of the recovery", records)
+			}
+		})
+	}
+}
+
+func TestParseRegQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		key    string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "string value",
+			output: "\r\nHKEY_CURRENT_USER\\Environment\r\n    PATH    REG_SZ    C:\\bin;C:\\Program Files\\x\r\n\r\n",
+			key:    "PATH",
+			want:   "C:\\bin;C:\\Program Files\\x",
+			wantOK: true,
+		},
+		{
+			name:   "case insensitive name",
+			output: "    Path    REG_EXPAND_SZ    %USERPROFILE%\\bin\n",
+			key:    "PATH",
+			want:   "%USERPROFILE%\\bin",
+			wantOK: true,
+		},
+		{
+			name:   "empty value",
+			output: "    MAMBA_GITHOOK_DIR    REG_SZ    \n",
+			key:    "MAMBA_GITHOOK_DIR",
+			want:   "",
+			wantOK: true,
+		},
+		{
+			name:   "unparsable",
+			output: "HKEY_CURRENT_USER\\Environment\n",
+			key:    "PATH",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRegQuery(tt.output, tt.key)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseRegQuery() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -1,50 +1,70 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
-
-	"github.com/aydabd/mamba-githook/installer/internal/log"
 )
 
-func (i *Installer) setupUnixEnvironment(envVars []string) error {
-	configFile := i.getShellConfigFile()
-	if configFile == "" {
-		return fmt.Errorf("unable to determine shell configuration file")
-	}
-
-	lines := make([]string, len(envVars))
-	for j, envVar := range envVars {
-		lines[j] = fmt.Sprintf("export %s", envVar)
+func (i *Installer) setupUnixEnvironment(ctx context.Context, shells []string) error {
+	for _, name := range shells {
+		sh, err := lookupShell(name)
+		if err != nil {
+			return err
+		}
+		if err := i.writeManagedBlock(ctx, sh.ConfigFile(i.HomeDir), i.envLines(sh)); err != nil {
+			return fmt.Errorf("failed to configure %s: %w", name, err)
+		}
 	}
-
-	return i.appendLinesToFile(configFile, lines)
+	return nil
 }
 
-func (i *Installer) removeUnixEnvironment() error {
-	configFile := i.getShellConfigFile()
-	if configFile == "" {
-		return fmt.Errorf("unable to determine shell configuration file")
+func (i *Installer) removeUnixEnvironment(ctx context.Context, shells []string) error {
+	for _, name := range shells {
+		sh, err := lookupShell(name)
+		if err != nil {
+			return err
+		}
+		if err := i.writeManagedBlock(ctx, sh.ConfigFile(i.HomeDir), nil); err != nil {
+			return fmt.Errorf("failed to remove %s configuration: %w", name, err)
+		}
 	}
-
-	return i.removeLinesByContent(configFile, []string{i.BinDir, i.TargetDir})
+	return nil
 }
 
-func (i *Installer) checkUnixEnvVars() error {
-	configFile := i.getShellConfigFile()
-	content, err := os.ReadFile(configFile)
-	if err != nil {
-		return fmt.Errorf("failed to read shell config file: %w", err)
-	}
+// checkUnixEnvVars reports, per shell, whether the managed rc block sets
+// PATH and MAMBA_GITHOOK_DIR as the installer would.
+func (i *Installer) checkUnixEnvVars(ctx context.Context, shells []string) ([]ShellStatus, error) {
+	var statuses []ShellStatus
+	for _, name := range shells {
+		sh, err := lookupShell(name)
+		if err != nil {
+			return nil, err
+		}
 
-	if !strings.Contains(string(content), i.BinDir) {
-		log.Warn().Msg("mamba-githook directory is not in PATH")
+		status, err := checkManagedBlock(name, sh.ConfigFile(i.HomeDir), i.envLines(sh))
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
 	}
 
-	if !strings.Contains(string(content), fmt.Sprintf("MAMBA_GITHOOK_DIR=%s", i.TargetDir)) {
-		log.Warn().Msg("MAMBA_GITHOOK_DIR is not set correctly")
+	return statuses, nil
+}
+
+// checkManagedBlock reports whether the managed block of a config file
+// holds the expected PATH and MAMBA_GITHOOK_DIR lines.
+func checkManagedBlock(shell, configFile string, expected []string) (ShellStatus, error) {
+	status := ShellStatus{Shell: shell, ConfigFile: configFile}
+	lines, blocks, err := readManagedBlock(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return status, fmt.Errorf("failed to read shell config file: %w", err)
 	}
+	status.Blocks = blocks
 
-	return nil
+	content := strings.Join(lines, "\n")
+	status.PathSet = strings.Contains(content, expected[0])
+	status.MambaGithookDirSet = strings.Contains(content, expected[1])
+	return status, nil
 }
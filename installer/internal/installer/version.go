@@ -0,0 +1,171 @@
+package installer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Commit and BuildDate describe the build. Builds without VCS stamping, such
+// as build-all with -buildvcs=false, set them with -ldflags "-X ...".
+var (
+	Commit    = ""
+	BuildDate = ""
+)
+
+// versionScript is the file of the payload that declares the mamba-githook
+// version, relative to the src directory.
+const versionScript = "utils/__version.sh"
+
+var semanticVersionPattern = regexp.MustCompile(`^\s*set_semantic_version\s+(\d+)\s+(\d+)\s+(\d+)\s*$`)
+
+// VersionInfo describes the installer build, the payload it embeds and the
+// installed mamba-githook.
+type VersionInfo struct {
+	Installer string `json:"installer" yaml:"installer"`
+	Payload   string `json:"payload" yaml:"payload"`
+	Installed string `json:"installed,omitempty" yaml:"installed,omitempty"`
+	Commit    string `json:"commit,omitempty" yaml:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty" yaml:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty" yaml:"modified,omitempty"`
+	GoVersion string `json:"go_version" yaml:"go_version"`
+}
+
+// parseVersionScript returns the version set by the set_semantic_version
+// call of __version.sh.
+func parseVersionScript(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if match := semanticVersionPattern.FindStringSubmatch(scanner.Text()); match != nil {
+			return strings.Join(match[1:], "."), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no set_semantic_version call found")
+}
+
+// PayloadVersion returns the mamba-githook version embedded in the installer.
+func (i *Installer) PayloadVersion() (string, error) {
+	f, err := i.SrcFS.Open("src/" + versionScript)
+	if err != nil {
+		return "", fmt.Errorf("failed to open embedded %s: %w", versionScript, err)
+	}
+	defer f.Close()
+
+	version, err := parseVersionScript(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read embedded payload version: %w", err)
+	}
+	return version, nil
+}
+
+// InstalledVersion returns the version of the installed mamba-githook, or an
+// empty string when it is not installed. Installations without a recorded
+// payload version are read from the installed __version.sh.
+func (i *Installer) InstalledVersion(ctx context.Context) (string, error) {
+	manifest, err := i.loadManifest()
+	if err != nil {
+		return "", err
+	}
+	if manifest != nil && manifest.PayloadVersion != "" {
+		return manifest.PayloadVersion, nil
+	}
+
+	f, err := os.Open(filepath.Join(i.TargetDir, versionScript))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	version, err := parseVersionScript(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read installed version: %w", err)
+	}
+	return version, nil
+}
+
+// VersionInfo collects the installer, payload and installed versions.
+func (i *Installer) VersionInfo(ctx context.Context) (*VersionInfo, error) {
+	info := &VersionInfo{
+		Installer: Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = build.GoVersion
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified, _ = strconv.ParseBool(setting.Value)
+			}
+		}
+	}
+
+	var err error
+	if info.Payload, err = i.PayloadVersion(); err != nil {
+		return nil, err
+	}
+	if info.Installed, err = i.InstalledVersion(ctx); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// PrintVersion prints the version information in the given output format:
+// text, json or yaml.
+func (i *Installer) PrintVersion(ctx context.Context, output string, w io.Writer) error {
+	info, err := i.VersionInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if output != "text" {
+		if err := encodeOutput(w, output, info); err != nil {
+			return fmt.Errorf("failed to encode version: %w", err)
+		}
+		return nil
+	}
+
+	installed := info.Installed
+	if installed == "" {
+		installed = "not installed"
+	}
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	} else if info.Modified {
+		commit += " (modified)"
+	}
+	buildDate := info.BuildDate
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+
+	fmt.Fprintf(w, "Installer:  %s\n", info.Installer)
+	fmt.Fprintf(w, "Payload:    %s\n", info.Payload)
+	fmt.Fprintf(w, "Installed:  %s\n", installed)
+	fmt.Fprintf(w, "Commit:     %s\n", commit)
+	fmt.Fprintf(w, "Build date: %s\n", buildDate)
+	fmt.Fprintf(w, "Go version: %s\n", info.GoVersion)
+	return nil
+}
@@ -1,46 +1,126 @@
 package installer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
-
-	"github.com/aydabd/mamba-githook/installer/internal/log"
 )
 
-func (i *Installer) setupWindowsEnvironment(envVars []string) error {
+func (i *Installer) setupWindowsEnvironment(ctx context.Context, envVars []string) error {
 	for _, envVar := range envVars {
 		parts := strings.SplitN(envVar, "=", 2)
-		cmd := exec.Command("setx", parts[0], parts[1])
-		if err := cmd.Run(); err != nil {
+		if err := i.setUserEnv(ctx, parts[0], parts[1]); err != nil {
 			return fmt.Errorf("failed to set environment variable %s: %w", parts[0], err)
 		}
 	}
 	return nil
 }
 
-func (i *Installer) removeWindowsEnvironment() error {
+func (i *Installer) removeWindowsEnvironment(ctx context.Context) error {
 	envVars := []string{"PATH", "MAMBA_GITHOOK_DIR"}
+	if i.MicromambaDir != "" {
+		envVars = append(envVars, micromambaDirEnv)
+	}
 	for _, envVar := range envVars {
-		cmd := exec.Command("reg", "delete", "HKCU\\Environment", "/F", "/V", envVar)
-		if err := cmd.Run(); err != nil {
+		if err := i.unsetUserEnv(ctx, envVar); err != nil {
 			return fmt.Errorf("failed to remove environment variable %s: %w", envVar, err)
 		}
 	}
 	return nil
 }
 
-func (i *Installer) checkWindowsEnvVars() error {
-	path := os.Getenv("PATH")
-	if !strings.Contains(path, i.BinDir) {
-		log.Warn().Msg("mamba-githook directory is not in PATH")
+// checkWindowsEnvVars reports whether the user environment holds the
+// PATH and MAMBA_GITHOOK_DIR values set by the installer.
+func (i *Installer) checkWindowsEnvVars(ctx context.Context) ShellStatus {
+	status := ShellStatus{Shell: "windows", ConfigFile: "HKCU\\Environment"}
+
+	path, _, err := getUserEnv(ctx, "PATH")
+	if err != nil {
+		path = os.Getenv("PATH")
 	}
+	status.PathSet = strings.Contains(path, i.BinDir)
 
-	mambaGithookDir := os.Getenv("MAMBA_GITHOOK_DIR")
-	if mambaGithookDir != i.TargetDir {
-		log.Warn().Msg("MAMBA_GITHOOK_DIR is not set correctly")
+	mambaGithookDir, _, err := getUserEnv(ctx, "MAMBA_GITHOOK_DIR")
+	if err != nil {
+		mambaGithookDir = os.Getenv("MAMBA_GITHOOK_DIR")
 	}
+	status.MambaGithookDirSet = mambaGithookDir == i.TargetDir
 
-	return nil
+	return status
+}
+
+// getUserEnv reads a user environment variable from the registry. ok is
+// false if the variable is not set.
+func getUserEnv(ctx context.Context, key string) (value string, ok bool, err error) {
+	output, err := exec.CommandContext(ctx, "reg", "query", "HKCU\\Environment", "/V", key).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read environment variable %s: %w", key, err)
+	}
+
+	value, ok = parseRegQuery(string(output), key)
+	if !ok {
+		// Backing up an unparsed value would restore it as empty
+		return "", false, fmt.Errorf("failed to parse environment variable %s from reg query output", key)
+	}
+	return value, true, nil
+}
+
+// parseRegQuery returns the value of key in the output of reg query, of the
+// form "    NAME    REG_SZ    value".
+func parseRegQuery(output, key string) (string, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.EqualFold(fields[0], key) && strings.HasPrefix(fields[1], "REG_") {
+			_, value, _ := strings.Cut(strings.TrimSpace(line), fields[1])
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+// setUserEnv sets a user environment variable with setx, journaling its
+// previous value.
+func (i *Installer) setUserEnv(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "setx", Target: key, Detail: value})
+		return nil
+	}
+	if err := i.journalUserEnv(ctx, key); err != nil {
+		return err
+	}
+	return setUserEnv(ctx, key, value)
+}
+
+// unsetUserEnv removes a user environment variable from the registry,
+// journaling its previous value.
+func (i *Installer) unsetUserEnv(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if i.DryRun {
+		i.plan.add(PlanAction{Op: "reg-delete", Target: "HKCU\\Environment", Detail: key})
+		return nil
+	}
+	if err := i.journalUserEnv(ctx, key); err != nil {
+		return err
+	}
+	return unsetUserEnv(ctx, key)
+}
+
+func setUserEnv(ctx context.Context, key, value string) error {
+	return exec.CommandContext(ctx, "setx", key, value).Run()
+}
+
+func unsetUserEnv(ctx context.Context, key string) error {
+	return exec.CommandContext(ctx, "reg", "delete", "HKCU\\Environment", "/F", "/V", key).Run()
 }
@@ -0,0 +1,204 @@
+package installer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// WizardAnswers holds the settings chosen in the install wizard.
+type WizardAnswers struct {
+	Config
+	// SavePath is the config file the answers are saved to, empty when they
+	// are not saved.
+	SavePath string
+}
+
+// prompter asks questions on a terminal, an empty answer selects the
+// default.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question with its default and returns the answer. At the end
+// of the input every question is answered with its default.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	if errors.Is(err, io.EOF) && line == "" {
+		fmt.Fprintln(p.out)
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line, nil
+	}
+	return def, nil
+}
+
+// confirm asks a yes or no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		answer, err := p.ask(question+" ("+choices+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "Please answer yes or no.")
+	}
+}
+
+// wizardDefaults returns the shells and the core.hooksPath scope the wizard
+// offers: the configured values, or those of the existing installation when
+// they are not configured so that reinstalling keeps its settings.
+func (i *Installer) wizardDefaults(cfg *EffectiveConfig) ([]string, string, error) {
+	shells, scope := cfg.Shells, cfg.HooksScope
+	installed, err := i.loadManifest()
+	if err != nil || installed == nil {
+		return shells, scope, err
+	}
+	if cfg.Sources["shells"] == SourceDefault && len(installed.Shells) > 0 {
+		shells = installed.Shells
+	}
+	if cfg.Sources["hooks_scope"] == SourceDefault {
+		scope = HooksScopeGlobal
+		if installed.HooksScope != "" {
+			scope = installed.HooksScope
+		}
+	}
+	return shells, scope, nil
+}
+
+// Wizard asks for the shells, the core.hooksPath scope, whether to install
+// micromamba and the install prefix, showing the values of cfg and what was
+// detected as defaults. Unless savePath is given, it also asks whether to
+// save the answers to the config file of cfg. Settings that were configured
+// but not asked for are kept in the answers.
+func (i *Installer) Wizard(ctx context.Context, in io.Reader, out io.Writer, cfg *EffectiveConfig, savePath string) (*WizardAnswers, error) {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	answers := &WizardAnswers{Config: *cfg.Explicit(), SavePath: savePath}
+	shells, scope, err := i.wizardDefaults(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(out, "mamba-githook installation, press Enter to accept the value in brackets.")
+	fmt.Fprintln(out)
+
+	if i.OS != "windows" && !i.System {
+		fmt.Fprintf(out, "Detected shell: %s, supported shells: %s\n", detectShell(), strings.Join(supportedShells(), ", "))
+		for {
+			answer, err := p.ask("Shells to configure, comma separated", strings.Join(shells, ","))
+			if err != nil {
+				return nil, err
+			}
+			shells, err := parseShells(answer)
+			if err == nil {
+				answers.Shells = shells
+				break
+			}
+			fmt.Fprintln(out, err)
+		}
+		fmt.Fprintln(out)
+	}
+
+	if current, ok, err := gitConfigGet(ctx, i.gitScope(), "core.hooksPath"); err == nil && ok && !i.isOwnHooksPath(current) {
+		fmt.Fprintf(out, "core.hooksPath is currently set to %s\n", current)
+	}
+	fmt.Fprintf(out, "With %s the mamba-githook hooks run in every repository, with %s\n", HooksScopeGlobal, HooksScopeRepo)
+	fmt.Fprintln(out, "core.hooksPath is left untouched and set in each repository that uses them.")
+	for {
+		answer, err := p.ask("Set core.hooksPath globally or per repository ("+HooksScopeGlobal+"/"+HooksScopeRepo+")", scope)
+		if err != nil {
+			return nil, err
+		}
+		if answer == HooksScopeGlobal || answer == HooksScopeRepo {
+			answers.HooksScope = answer
+			break
+		}
+		fmt.Fprintf(out, "Please answer %s or %s.\n", HooksScopeGlobal, HooksScopeRepo)
+	}
+	fmt.Fprintln(out)
+
+	micromamba := i.findMicromamba()
+	installDefault := !micromamba.Present
+	if micromamba.Present {
+		fmt.Fprintf(out, "micromamba found: %s\n", micromamba.Path)
+	} else {
+		fmt.Fprintf(out, "micromamba not found on PATH or at %s, the hooks install it on first use otherwise.\n", micromamba.Path)
+	}
+	if cfg.InstallMicromamba != nil {
+		installDefault = *cfg.InstallMicromamba
+	}
+	installMicromamba, err := p.confirm("Install micromamba now?", installDefault)
+	if err != nil {
+		return nil, err
+	}
+	answers.InstallMicromamba = &installMicromamba
+	fmt.Fprintln(out)
+
+	fmt.Fprintf(out, "mamba-githook is installed into %s and %s unless a prefix is given.\n", i.unrooted(i.BinDir), i.unrooted(i.TargetDir))
+	prefix, err := p.ask("Install prefix, empty for these directories", cfg.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	answers.Prefix = prefix
+	fmt.Fprintln(out)
+
+	if savePath == "" {
+		save, err := p.confirm(fmt.Sprintf("Save these answers to %s to replay them with 'install --non-interactive'?", cfg.File), true)
+		if err != nil {
+			return nil, err
+		}
+		if save {
+			answers.SavePath = cfg.File
+		}
+	}
+	return answers, nil
+}
+
+// parseShells splits a comma separated list of shells and checks that each
+// one is supported.
+func parseShells(s string) ([]string, error) {
+	var shells []string
+	for _, shell := range strings.Split(s, ",") {
+		if shell = strings.TrimSpace(shell); shell == "" {
+			continue
+		}
+		if _, err := lookupShell(shell); err != nil {
+			return nil, err
+		}
+		shells = append(shells, shell)
+	}
+	if len(shells) == 0 {
+		return nil, errors.New("at least one shell is required")
+	}
+	return shells, nil
+}
+
+// IsTerminal reports whether f is an interactive terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,81 @@
+package installer
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestWizardDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed *Manifest
+		// configured maps the keys set by the config file, the environment
+		// or the flags to their value.
+		configured map[string]string
+		wantShells []string
+		wantScope  string
+	}{
+		{
+			name:       "not installed",
+			wantShells: []string{"bash"},
+			wantScope:  HooksScopeGlobal,
+		},
+		{
+			name:       "installed settings",
+			installed:  &Manifest{Shells: []string{"zsh", "fish"}, HooksScope: HooksScopeRepo},
+			wantShells: []string{"zsh", "fish"},
+			wantScope:  HooksScopeRepo,
+		},
+		{
+			name:       "installed globally",
+			installed:  &Manifest{Shells: []string{"zsh"}},
+			wantShells: []string{"zsh"},
+			wantScope:  HooksScopeGlobal,
+		},
+		{
+			name:       "installed without shells",
+			installed:  &Manifest{HooksScope: HooksScopeRepo},
+			wantShells: []string{"bash"},
+			wantScope:  HooksScopeRepo,
+		},
+		{
+			name:       "configuration wins",
+			installed:  &Manifest{Shells: []string{"zsh"}, HooksScope: HooksScopeRepo},
+			configured: map[string]string{"shells": "fish", "hooks_scope": HooksScopeGlobal},
+			wantShells: []string{"fish"},
+			wantScope:  HooksScopeGlobal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &Installer{TargetDir: t.TempDir()}
+			if tt.installed != nil {
+				if err := i.writeManifest(context.Background(), tt.installed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := &EffectiveConfig{
+				Config:  Config{Shells: []string{"bash"}, HooksScope: HooksScopeGlobal},
+				Sources: map[string]string{"shells": SourceDefault, "hooks_scope": SourceDefault},
+			}
+			if shells, ok := tt.configured["shells"]; ok {
+				cfg.Shells = []string{shells}
+				cfg.Sources["shells"] = SourceFile
+			}
+			if scope, ok := tt.configured["hooks_scope"]; ok {
+				cfg.HooksScope = scope
+				cfg.Sources["hooks_scope"] = SourceFlag
+			}
+
+			shells, scope, err := i.wizardDefaults(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(shells, tt.wantShells) || scope != tt.wantScope {
+				t.Errorf("wizardDefaults() = %v, %s, want %v, %s", shells, scope, tt.wantShells, tt.wantScope)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Outcomes of an audited operation.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// AuditRecord is a single entry of the audit log: one installer operation
+// and the changes it made.
+type AuditRecord struct {
+	Time      time.Time `json:"time" yaml:"time"`
+	Operation string    `json:"operation" yaml:"operation"`
+	// OperationID matches the op_id field of the log messages of the
+	// operation.
+	OperationID string `json:"operation_id,omitempty" yaml:"operation_id,omitempty"`
+	// Command is the full command line of the installer.
+	Command string `json:"command" yaml:"command"`
+	User    string `json:"user" yaml:"user"`
+	Host    string `json:"host" yaml:"host"`
+	Version string `json:"version" yaml:"version"`
+	// Files lists the files written or removed.
+	Files     []string          `json:"files,omitempty" yaml:"files,omitempty"`
+	GitConfig []GitConfigChange `json:"git_config,omitempty" yaml:"git_config,omitempty"`
+	Outcome   string            `json:"outcome" yaml:"outcome"`
+	Error     string            `json:"error,omitempty" yaml:"error,omitempty"`
+	// DurationMS is the duration of the operation in milliseconds.
+	DurationMS int64 `json:"duration_ms" yaml:"duration_ms"`
+}
+
+// GitConfigChange is a git config entry set or unset by an operation.
+type GitConfigChange struct {
+	Scope    string `json:"scope" yaml:"scope"`
+	Key      string `json:"key" yaml:"key"`
+	Value    string `json:"value,omitempty" yaml:"value,omitempty"`
+	Previous string `json:"previous,omitempty" yaml:"previous,omitempty"`
+	Unset    bool   `json:"unset,omitempty" yaml:"unset,omitempty"`
+}
+
+// WriteAudit appends record as a JSON line to the audit log at path. Records
+// are never rewritten, the log only grows.
+func WriteAudit(path string, record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	// A single write keeps concurrent records from interleaving
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// ReadAudit returns the records of the audit log at path, oldest first. A
+// missing log has no records.
+func ReadAudit(path string) ([]AuditRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log %s line %d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,74 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type operationKey struct{}
+
+// operation identifies a running installer operation.
+type operation struct {
+	name  string
+	id    string
+	start time.Time
+	// logger is the logger of the operation without a step.
+	logger zerolog.Logger
+}
+
+// elapsedHook adds the time since the start of the operation to every
+// event.
+type elapsedHook struct {
+	start time.Time
+}
+
+func (h elapsedHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	e.Str("elapsed", time.Since(h.start).Round(time.Millisecond).String())
+}
+
+// WithOperation returns a context whose logger tags every message with the
+// operation name, a new operation ID and the elapsed time. Within a running
+// operation ctx is returned unchanged, so nested operations share the ID of
+// the outer one.
+func WithOperation(ctx context.Context, name string) context.Context {
+	if _, ok := ctx.Value(operationKey{}).(*operation); ok {
+		return ctx
+	}
+
+	op := &operation{name: name, id: newOperationID(), start: time.Now()}
+	op.logger = Ctx(ctx).zl.With().Str("op", op.name).Str("op_id", op.id).Logger().Hook(elapsedHook{start: op.start})
+	ctx = context.WithValue(ctx, operationKey{}, op)
+	return op.logger.WithContext(ctx)
+}
+
+// WithStep returns a context whose logger tags every message with the step
+// of the operation, replacing the previous step.
+func WithStep(ctx context.Context, step string) context.Context {
+	zl := Ctx(ctx).zl
+	if op, ok := ctx.Value(operationKey{}).(*operation); ok {
+		zl = op.logger
+	}
+	zl = zl.With().Str("step", step).Logger()
+	return zl.WithContext(ctx)
+}
+
+// OperationID returns the ID of the operation running in ctx, or an empty
+// string outside of an operation.
+func OperationID(ctx context.Context) string {
+	if op, ok := ctx.Value(operationKey{}).(*operation); ok {
+		return op.id
+	}
+	return ""
+}
+
+func newOperationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,82 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// logfmtWriter converts the JSON log events of zerolog to logfmt lines of
+// key=value pairs.
+type logfmtWriter struct {
+	out io.Writer
+}
+
+func (w logfmtWriter) Write(p []byte) (int, error) {
+	var event map[string]any
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err := dec.Decode(&event); err != nil {
+		return 0, fmt.Errorf("failed to decode log event: %w", err)
+	}
+
+	// The common fields come first, the others sorted by name
+	keys := []string{zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.CallerFieldName, zerolog.MessageFieldName}
+	var rest []string
+	for key := range event {
+		if !slices.Contains(keys, key) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	var line strings.Builder
+	for _, key := range append(keys, rest...) {
+		value, ok := event[key]
+		if !ok {
+			continue
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(key)
+		line.WriteByte('=')
+		line.WriteString(logfmtValue(value))
+	}
+	line.WriteByte('\n')
+
+	if _, err := io.WriteString(w.out, line.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// logfmtValue formats a value, quoting it when it is empty or contains
+// spaces, quotes or equal signs.
+func logfmtValue(value any) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case json.Number, bool:
+		s = fmt.Sprint(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(data)
+		}
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
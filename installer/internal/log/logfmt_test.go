@@ -0,0 +1,70 @@
+package log
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLogfmtWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "common fields first",
+			event: `{"zeta":"z","message":"done","level":"info","alpha":"a","time":"2024-01-02T03:04:05Z"}`,
+			want:  "time=2024-01-02T03:04:05Z level=info message=done alpha=a zeta=z\n",
+		},
+		{
+			name:  "caller after level",
+			event: `{"caller":"main.go:10","level":"debug","message":"x"}`,
+			want:  "level=debug caller=main.go:10 message=x\n",
+		},
+		{
+			name:  "quoted values",
+			event: `{"message":"two words","eq":"a=b","quote":"say \"hi\"","empty":"","newline":"a\nb"}`,
+			want:  `message="two words" empty="" eq="a=b" newline="a\nb" quote="say \"hi\""` + "\n",
+		},
+		{
+			name:  "numbers keep their precision",
+			event: `{"big":12345678901234567890,"float":1.5,"int":3}`,
+			want:  "big=12345678901234567890 float=1.5 int=3\n",
+		},
+		{
+			name:  "booleans and null",
+			event: `{"ok":true,"failed":false,"none":null}`,
+			want:  "failed=false none=null ok=true\n",
+		},
+		{
+			name:  "nested values as JSON",
+			event: `{"list":["a","b"],"object":{"k":1}}`,
+			want:  `list="[\"a\",\"b\"]" object="{\"k\":1}"` + "\n",
+		},
+		{
+			name:    "invalid event",
+			event:   `not json`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := logfmtWriter{out: &out}.Write([]byte(tt.event + "\n"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if n != len(tt.event)+1 {
+				t.Errorf("Write() = %d, want %d", n, len(tt.event)+1)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Write() wrote\n%q\nwant\n%q", out.String(), tt.want)
+			}
+		})
+	}
+}
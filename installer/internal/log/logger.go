@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -12,13 +15,59 @@ import (
 
 var defaultLogger Logger
 
+var (
+	// format is the log format of the terminal output.
+	format = "console"
+	// noColor disables colors in the console format, see https://no-color.org.
+	noColor = os.Getenv("NO_COLOR") != ""
+	// file receives every log event as JSON when set.
+	file io.Writer
+	// caller adds the source file and line of every log event, only at
+	// trace level to keep the output readable.
+	caller bool
+)
+
 type Logger struct {
 	zl zerolog.Logger
 }
 
 func init() {
-	output := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+	defaultLogger = Logger{zl: newLogger()}
+
+	// Set default level to Info
+	SetGlobalLevel(zerolog.InfoLevel)
+}
+
+func newLogger() zerolog.Logger {
+	ctx := zerolog.New(output()).With().Timestamp()
+	if caller {
+		ctx = ctx.Caller()
+	}
+	return ctx.Logger()
+}
+
+// output returns the writer of log events: stderr in the selected format,
+// so that stdout only carries command results, and the log file if any.
+func output() io.Writer {
+	var w io.Writer
+	switch format {
+	case "json":
+		w = os.Stderr
+	case "logfmt":
+		w = logfmtWriter{out: os.Stderr}
+	default:
+		w = consoleWriter(os.Stderr)
+	}
+	if file != nil {
+		return zerolog.MultiLevelWriter(w, file)
+	}
+	return w
+}
+
+func consoleWriter(out io.Writer) zerolog.ConsoleWriter {
+	return zerolog.ConsoleWriter{
+		Out:        out,
+		NoColor:    noColor,
 		TimeFormat: time.RFC3339,
 		FormatMessage: func(i interface{}) string {
 			return fmt.Sprintf("%-50s", i)
@@ -30,20 +79,85 @@ func init() {
 			return fmt.Sprintf("%s", i)
 		},
 	}
+}
 
-	zl := zerolog.New(output).With().Timestamp().Caller().Logger()
-	defaultLogger = Logger{zl: zl}
+// SetFormat selects the log format: console for human readable output,
+// json for one JSON object per line or logfmt for key=value pairs.
+func SetFormat(f string) error {
+	switch f {
+	case "console", "json", "logfmt":
+	default:
+		return fmt.Errorf("unknown log format %q, expected console, json or logfmt", f)
+	}
+	format = f
+	defaultLogger.zl = newLogger()
+	return nil
+}
 
-	// Set default level to Info
-	SetGlobalLevel(zerolog.InfoLevel)
+// SetNoColor disables colors in the console format.
+func SetNoColor() {
+	noColor = true
+	defaultLogger.zl = newLogger()
+}
+
+// SetFile additionally writes every log event as JSON to the file at path,
+// appending to it.
+func SetFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	file = f
+	defaultLogger.zl = newLogger()
+	return nil
+}
+
+// OnFatal registers f to run after a fatal message is logged, right before
+// the program exits.
+func OnFatal(f func()) {
+	zerolog.FatalExitFunc = func() {
+		f()
+		os.Exit(1)
+	}
 }
 
 func SetGlobalLevel(level zerolog.Level) {
 	zerolog.SetGlobalLevel(level)
 }
 
-func SetVerbose() {
-	SetGlobalLevel(zerolog.DebugLevel)
+// Levels lists the log level names accepted by SetLevel, most verbose
+// first.
+var Levels = []string{"trace", "debug", "info", "warn", "error"}
+
+// SetLevel sets the minimum level of the logged messages by name. The trace
+// level also adds the caller of every message.
+func SetLevel(name string) error {
+	level, err := zerolog.ParseLevel(name)
+	if err != nil || !slices.Contains(Levels, name) {
+		return fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(Levels, ", "))
+	}
+	SetGlobalLevel(level)
+	if trace := level == zerolog.TraceLevel; trace != caller {
+		caller = trace
+		defaultLogger.zl = newLogger()
+	}
+	return nil
+}
+
+// VerbosityLevel returns the level name selected by the number of -v flags:
+// debug for one, trace for more.
+func VerbosityLevel(count int) string {
+	switch {
+	case count <= 0:
+		return "info"
+	case count == 1:
+		return "debug"
+	default:
+		return "trace"
+	}
 }
 
 func SetOutput(w io.Writer) {
@@ -106,9 +220,38 @@ func Printf(format string, v ...interface{}) {
 }
 
 // Ctx returns the Logger associated with the ctx. If no logger
-// is associated, a disabled logger is returned.
+// is associated, the default logger is returned.
 func Ctx(ctx context.Context) *Logger {
-	return &Logger{zl: *zerolog.Ctx(ctx)}
+	if zl := zerolog.Ctx(ctx); zl != zerolog.DefaultContextLogger && zl.GetLevel() != zerolog.Disabled {
+		return &Logger{zl: *zl}
+	}
+	return &defaultLogger
+}
+
+// Debug starts a new message with debug level.
+func (l *Logger) Debug() *zerolog.Event {
+	return l.zl.Debug()
+}
+
+// Info starts a new message with info level.
+func (l *Logger) Info() *zerolog.Event {
+	return l.zl.Info()
+}
+
+// Warn starts a new message with warn level.
+func (l *Logger) Warn() *zerolog.Event {
+	return l.zl.Warn()
+}
+
+// Error starts a new message with error level.
+func (l *Logger) Error() *zerolog.Event {
+	return l.zl.Error()
+}
+
+// Fatal starts a new message with fatal level. The os.Exit(1) function
+// is called by the Msg method.
+func (l *Logger) Fatal() *zerolog.Event {
+	return l.zl.Fatal()
 }
 
 // With creates a child logger with the field added to its context.
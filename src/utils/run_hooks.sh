@@ -14,7 +14,8 @@
 #       pre-commit.priority.hook_name.
 #       Priority is optional. Priority is used to
 #       determine the order of the hooks. Lower
-#       values have higher priority.
+#       values have higher priority, hooks without
+#       a priority run last.
 #
 # Example:
 #   hooks_runner pre-commit .githooks.d
@@ -63,6 +64,72 @@ __get_hook_type() {
   printf "${hook_type}\n"
 }
 
+__get_hook_priority() {
+  #################################################
+  # Gets the priority of the hook. The priority is
+  # the second part of the hook name. Hooks without
+  # a priority get the largest one and run last.
+  #
+  # Example:
+  #   __get_hook_priority pre-commit.40.jira
+  #   # Output: 40
+  #   __get_hook_priority pre-push.jira
+  #   # Output: 999999999
+  #
+  # Args:
+  #   $1: Hook script name
+  #
+  # Returns:
+  #   0 if the command is successful, 1 otherwise.
+  #################################################
+  hook_name="$1"
+  hook_priority="${hook_name#*.}"
+  hook_priority="${hook_priority%%.*}"
+  case "${hook_name}:${hook_priority}" in
+  *.*:?*)
+    case "${hook_priority}" in
+    *[!0-9]*) hook_priority=999999999 ;;
+    esac
+    ;;
+  *) hook_priority=999999999 ;;
+  esac
+  printf "%s\n" "${hook_priority}"
+}
+
+__sort_hooks() {
+  #################################################
+  # Lists the executable hooks of the hook type in
+  # the directory, ordered by numeric priority,
+  # lowest first, then by name.
+  #
+  # Example:
+  #   __sort_hooks pre-commit .githooks.d
+  #   # Output:
+  #   # pre-commit.40.jira
+  #   # pre-commit.100.lint
+  #   # pre-commit.style
+  #
+  # Args:
+  #   $1: Hook type
+  #   $2: Path to the custom hooks directory
+  #
+  # Returns:
+  #   0 if the command is successful, 1 otherwise.
+  #################################################
+  hook_type="$1"
+  githooks_dir="$2"
+  for hook in "${githooks_dir}"/*; do
+    log_debug "Checking" "${hook}"
+    if [ -x "${hook}" ]; then
+      hook_name=$(basename "${hook}")
+      file_hook_type=$(__get_hook_type "${hook_name}")
+      if [ "${file_hook_type}" = "${hook_type}" ]; then
+        printf "%s %s\n" "$(__get_hook_priority "${hook_name}")" "${hook_name}"
+      fi
+    fi
+  done | sort -k1,1n -k2 | cut -d' ' -f2-
+}
+
 get_available_hooks_types() {
   ###################################################
   # Gets the available hook types from git help hooks
@@ -141,19 +208,17 @@ hooks_runner() {
   }
   sh_cm_is_dir_exist "${githooks_dir}"
   log_debug "Running the" "${hook_type}" "hooks in" "${githooks_dir}"
-  for hook in "${githooks_dir}"/*; do
-    log_debug "Checking" "${hook}"
-    if [ -x "${hook}" ]; then
-      hook_name=$(basename "${hook}")
-      file_hook_type=$(__get_hook_type "${hook_name}")
-      if [ "${file_hook_type}" = "${hook_type}" ]; then
-        log_info "Running" "${hook_name}"
-        "${hook}" || {
-          log_warning "Failed to run" "${hook_name}"
-          failed=1
-        }
-      fi
-    fi
-  done
+  hooks=$(__sort_hooks "${hook_type}" "${githooks_dir}")
+  # Read the hooks from fd 3, the hooks get the stdin of the git hook
+  while IFS= read -r hook_name <&3; do
+    test -n "${hook_name}" || continue
+    log_info "Running" "${hook_name}"
+    "${githooks_dir}/${hook_name}" || {
+      log_warning "Failed to run" "${hook_name}"
+      failed=1
+    }
+  done 3<<EOF
+${hooks}
+EOF
   return "${failed}"
 }